@@ -0,0 +1,154 @@
+// Package metrics defines the prometheus metrics of the api server.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dropbox"
+
+// Result labels.
+const (
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of handled HTTP requests by route, status and result.",
+	}, []string{"route", "method", "status", "result"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the handled HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// UploadBytes counts the bytes of file content received.
+	UploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Number of file content bytes received.",
+	})
+	uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Duration of the uploads by result.",
+		Buckets:   transferBuckets,
+	}, []string{"result"})
+
+	// DownloadBytes counts the bytes of object content served.
+	DownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Number of object content bytes served.",
+	})
+	downloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of the downloads by result.",
+		Buckets:   transferBuckets,
+	}, []string{"result"})
+
+	depotRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "depot_rpc_duration_seconds",
+		Help:      "Duration of the depot RPCs by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	depotRPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "depot_rpc_errors_total",
+		Help:      "Number of failed depot RPCs by method.",
+	}, []string{"method"})
+
+	taskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Duration of a loop of the background tasks.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"task"})
+
+	// Objects is the number of objects by status.
+	Objects = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "objects",
+		Help:      "Number of objects by status.",
+	}, []string{"status"})
+
+	// CIDBacklog is the number of objects waiting for their CID.
+	CIDBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cid_backlog",
+		Help:      "Number of committed objects without a CID.",
+	})
+)
+
+// transferBuckets span from 100ms to about 2 hours.
+var transferBuckets = prometheus.ExponentialBuckets(0.1, 3, 11)
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records a request handled for route with the response
+// status.
+func ObserveRequest(
+	route string,
+	method string,
+	status int,
+	start time.Time,
+	ok bool,
+) {
+	httpRequests.WithLabelValues(
+		route,
+		method,
+		strconv.Itoa(status),
+		result(ok),
+	).Inc()
+	httpDuration.WithLabelValues(route, method).
+		Observe(time.Since(start).Seconds())
+}
+
+// ObserveUpload records an upload started at start.
+func ObserveUpload(start time.Time, err error) {
+	uploadDuration.WithLabelValues(result(err == nil)).
+		Observe(time.Since(start).Seconds())
+}
+
+// ObserveDownload records a download started at start.
+func ObserveDownload(start time.Time, err error) {
+	downloadDuration.WithLabelValues(result(err == nil)).
+		Observe(time.Since(start).Seconds())
+}
+
+// ObserveDepotRPC records a depot RPC of method started at start.
+func ObserveDepotRPC(method string, start time.Time, err error) {
+	depotRPCDuration.WithLabelValues(method).
+		Observe(time.Since(start).Seconds())
+	if err != nil {
+		depotRPCErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveTask records a loop of task started at start.
+func ObserveTask(task string, start time.Time) {
+	taskDuration.WithLabelValues(task).Observe(time.Since(start).Seconds())
+}
+
+func result(ok bool) string {
+	if ok {
+		return resultOK
+	}
+
+	return resultError
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/photo-storage/dropbox/api/service"
+)
+
+var (
+	requireRead  = requireScope(service.ScopeRead)
+	requireWrite = requireScope(service.ScopeWrite)
+	requireKeys  = requireScope(service.ScopeKeys)
+)
+
+// authenticate rejects the requests without valid credentials and
+// injects the authenticated principal into the context.
+func authenticate(svc *service.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.Authenticate(c); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requireScope rejects the requests whose principal is not granted
+// scope.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := service.CheckScope(c, scope); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/photon-storage/go-common/log"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/api/pagination"
 	"github.com/photo-storage/dropbox/api/service"
 )
@@ -159,6 +161,32 @@ func validateFunc(fn handleFunc) error {
 	return nil
 }
 
+// observe records every request, including the ones rejected by a
+// middleware or matching no route. Errors are reported with a 200
+// status and a code in the body, a request fails if it has an error or
+// a 4xx or 5xx status.
+func observe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func(start time.Time) {
+			route := c.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			status := c.Writer.Status()
+			metrics.ObserveRequest(
+				route,
+				c.Request.Method,
+				status,
+				start,
+				len(c.Errors) == 0 && status < http.StatusBadRequest,
+			)
+		}(time.Now())
+
+		c.Next()
+	}
+}
+
 func handleError() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
@@ -172,6 +200,11 @@ func handleError() gin.HandlerFunc {
 			"error", err,
 		)
 
+		if c.Writer.Written() {
+			abortConn(c)
+			return
+		}
+
 		msg := err.Error()
 		code := getErrCode(err.Err, service.ErrorCode)
 		if code == -1 {
@@ -185,6 +218,26 @@ func handleError() gin.HandlerFunc {
 	}
 }
 
+// abortConn drops the connection of a response whose status and part of
+// the body were already sent, so the client sees a truncated transfer
+// instead of an error message appended to the body. Where the connection
+// can't be hijacked (HTTP/2), the body still ends short of its declared
+// Content-Length, which the client detects as a failed transfer.
+func abortConn(c *gin.Context) {
+	c.Abort()
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		return
+	}
+
+	if err := conn.Close(); err != nil {
+		log.Error("close aborted connection failed",
+			"url", c.Request.URL,
+			"error", err,
+		)
+	}
+}
+
 func getErrCode(err error, errorCodes map[error]int) int {
 	if ok := isComparable(reflect.TypeOf(err)); ok {
 		if errCode, ok := errorCodes[err]; ok {
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/photo-storage/dropbox/api/service"
+)
+
+// bucketSweepInterval is how often the buckets refilled to their burst
+// are dropped.
+const bucketSweepInterval = time.Minute
+
+// RateLimitConfig defines the request rates allowed per client for
+// each class of routes.
+type RateLimitConfig struct {
+	// Auth limits every request by client address before it is
+	// authenticated, including the public account and login routes.
+	Auth     LimitConfig `yaml:"auth"`
+	Upload   LimitConfig `yaml:"upload"`
+	Download LimitConfig `yaml:"download"`
+	List     LimitConfig `yaml:"list"`
+}
+
+// LimitConfig defines a token bucket, a zero rate disables limiting.
+type LimitConfig struct {
+	// Rate is the number of requests per second.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests allowed at once.
+	Burst int `yaml:"burst"`
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket per client.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg LimitConfig) *rateLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:      cfg.Rate,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// take takes a token from the bucket of key. It returns the time to
+// wait for the next token if the bucket is empty.
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep drops the buckets which would be full by now, they are the
+// same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimit rejects the requests of the clients exceeding the rate of
+// l with a 429 response. Clients are told apart by key.
+func rateLimit(l *rateLimiter, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+
+		ok, wait := l.take(key(c), time.Now())
+		if !ok {
+			secs := int64(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.FormatInt(secs, 10))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, Response{
+				Code: service.ErrorCode[service.ErrRateLimited],
+				Msg:  service.ErrRateLimited.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// clientKey tells clients apart by their account or wallet key, or by
+// their address when not authenticated.
+func clientKey(c *gin.Context) string {
+	p := service.PrincipalOf(c)
+	switch {
+	case p.Account != "":
+		return "account:" + p.Account
+	case p.PublicKey != "":
+		return "wallet:" + p.PublicKey
+	default:
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// addressKey tells clients apart by their address only, it does not
+// depend on the request being authenticated.
+func addressKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
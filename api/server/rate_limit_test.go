@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	type take struct {
+		key  string
+		at   time.Duration
+		ok   bool
+		wait time.Duration
+	}
+
+	cases := []struct {
+		name  string
+		cfg   LimitConfig
+		takes []take
+	}{
+		{
+			name: "burst then empty",
+			cfg:  LimitConfig{Rate: 1, Burst: 2},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "a", ok: true},
+				{key: "a", ok: false, wait: time.Second},
+			},
+		},
+		{
+			name: "refill over time",
+			cfg:  LimitConfig{Rate: 2, Burst: 1},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "a", at: 250 * time.Millisecond, ok: false, wait: 250 * time.Millisecond},
+				{key: "a", at: 500 * time.Millisecond, ok: true},
+			},
+		},
+		{
+			name: "refill capped at burst",
+			cfg:  LimitConfig{Rate: 10, Burst: 2},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "a", at: time.Hour, ok: true},
+				{key: "a", at: time.Hour, ok: true},
+				{key: "a", at: time.Hour, ok: false, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "keys apart",
+			cfg:  LimitConfig{Rate: 1, Burst: 1},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "a", ok: false, wait: time.Second},
+				{key: "b", ok: true},
+			},
+		},
+		{
+			name: "zero burst allows one",
+			cfg:  LimitConfig{Rate: 1},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "a", ok: false, wait: time.Second},
+			},
+		},
+		{
+			name: "full buckets swept",
+			cfg:  LimitConfig{Rate: 1, Burst: 1},
+			takes: []take{
+				{key: "a", ok: true},
+				{key: "b", at: 2 * bucketSweepInterval, ok: true},
+				{key: "a", at: 2 * bucketSweepInterval, ok: true},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := newRateLimiter(c.cfg)
+			l.lastSweep = start
+			for i, tk := range c.takes {
+				ok, wait := l.take(tk.key, start.Add(tk.at))
+				if ok != tk.ok || wait != tk.wait {
+					t.Fatalf(
+						"take %d: got (%v, %v), want (%v, %v)",
+						i,
+						ok,
+						wait,
+						tk.ok,
+						tk.wait,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRateLimiterDisabled(t *testing.T) {
+	cases := []LimitConfig{
+		{},
+		{Rate: 0, Burst: 10},
+		{Rate: -1, Burst: 10},
+	}
+
+	for _, cfg := range cases {
+		if l := newRateLimiter(cfg); l != nil {
+			t.Fatalf("config %+v: got a limiter, want none", cfg)
+		}
+	}
+}
@@ -9,21 +9,38 @@ import (
 
 	"github.com/photon-storage/go-common/log"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/api/service"
 )
 
 // Server defines an instance of a server that handles the requests of
 // the third-party application.
 type Server struct {
-	port   int
-	engine *gin.Engine
+	port          int
+	metricsPort   int
+	engine        *gin.Engine
+	authLimit     gin.HandlerFunc
+	uploadLimit   gin.HandlerFunc
+	downloadLimit gin.HandlerFunc
+	listLimit     gin.HandlerFunc
 }
 
-// New returns a new instance of the server.
-func New(port int, service *service.Service) *Server {
+// New returns a new instance of the server. The metrics are served on
+// metricsPort, apart from the API, and not at all if it is zero.
+func New(
+	port int,
+	metricsPort int,
+	limits RateLimitConfig,
+	service *service.Service,
+) *Server {
 	server := &Server{
-		port:   port,
-		engine: gin.Default(),
+		port:          port,
+		metricsPort:   metricsPort,
+		engine:        gin.Default(),
+		authLimit:     rateLimit(newRateLimiter(limits.Auth), addressKey),
+		uploadLimit:   rateLimit(newRateLimiter(limits.Upload), clientKey),
+		downloadLimit: rateLimit(newRateLimiter(limits.Download), clientKey),
+		listLimit:     rateLimit(newRateLimiter(limits.List), clientKey),
 	}
 
 	server.registerRouter(service)
@@ -33,10 +50,9 @@ func New(port int, service *service.Service) *Server {
 func cors() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token")
-		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
-		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token, X-Dropbox-Duration, X-Dropbox-Fee, X-Dropbox-Pledge, X-Dropbox-Gas-Price, X-Dropbox-Replicas, X-Dropbox-Encoding, X-Dropbox-Sign-Blocks, X-Dropbox-Encrypt, X-Dropbox-Encryption-Key, X-Dropbox-Dedup, X-Dropbox-Path, X-Dropbox-Api-Key")
+		c.Header("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Retry-After, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -47,20 +63,65 @@ func cors() gin.HandlerFunc {
 }
 
 func (s *Server) registerRouter(service *service.Service) {
-	s.engine.Use(handleError(), cors())
-	g := s.engine.Group("dropbox/v1")
-
-	g.POST("upload", s.handle(service.Upload))
-	g.GET("download", s.handle(service.Download))
-	g.GET("objects", s.handle(service.Objects))
+	s.engine.Use(observe(), handleError(), cors())
 
+	// Requests are limited by address before authentication, so that
+	// credentials can not be guessed nor accounts created at will.
+	g := s.engine.Group("dropbox/v1", s.authLimit)
+	g.POST("accounts", s.handle(service.CreateAccount))
+	g.POST("auth/challenge", s.handle(service.CreateChallenge))
+	g.POST("auth/wallet", s.handle(service.WalletLogin))
 	g.GET("ping", s.handle(service.Ping))
+
+	g = g.Group("", authenticate(service))
+	read := g.Group("", requireRead)
+	read.GET("upload/sessions/:id", s.listLimit, s.handle(service.UploadSession))
+	read.GET("download", s.downloadLimit, s.handle(service.Download))
+	read.GET("objects", s.listLimit, s.handle(service.Objects))
+	read.GET("directories", s.listLimit, s.handle(service.Directory))
+	read.GET("quota", s.handle(service.Quota))
+
+	write := g.Group("", requireWrite)
+	write.POST("upload", s.uploadLimit, s.handle(service.Upload))
+	write.POST("upload/sessions", s.uploadLimit, s.handle(service.CreateUploadSession))
+	write.PUT("upload/sessions/:id", s.uploadLimit, s.handle(service.UploadSessionPart))
+	write.POST("upload/sessions/:id/complete", s.uploadLimit, s.handle(service.CompleteUploadSession))
+	write.POST("upload/sessions/:id/signature", s.uploadLimit, s.handle(service.SignUploadSession))
+	write.DELETE("upload/sessions/:id", s.handle(service.AbortUploadSession))
+	write.PUT("objects/:hash/retention", s.handle(service.SetRetention))
+	write.POST("objects/move", s.handle(service.MoveObject))
+	write.POST("directories", s.handle(service.CreateDirectory))
+	write.POST("directories/move", s.handle(service.MoveDirectory))
+
+	keys := g.Group("", requireKeys)
+	keys.POST("auth/token", s.handle(service.IssueToken))
+	keys.POST("keys", s.handle(service.CreateAPIKey))
+	keys.GET("keys", s.listLimit, s.handle(service.APIKeys))
+	keys.DELETE("keys/:id", s.handle(service.DeleteAPIKey))
 }
 
 // Run the server
 func (s *Server) Run() {
+	if s.metricsPort != 0 {
+		go s.runMetrics()
+	}
+
 	if err := s.engine.Run(fmt.Sprintf(":%d", s.port)); err != nil {
 		log.Error("run the server failed", "error", err)
 		os.Exit(1)
 	}
 }
+
+// runMetrics serves the metrics on their own listener, so that they are
+// not exposed on the API port.
+func (s *Server) runMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if err := http.ListenAndServe(
+		fmt.Sprintf(":%d", s.metricsPort),
+		mux,
+	); err != nil {
+		log.Error("run the metrics server failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes.
+	maxPasswordLength = 72
+)
+
+var accountNamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+type createAccountReq struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type account struct {
+	Name      string `json:"name"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// CreateAccount handles the POST /accounts request.
+func (s *Service) CreateAccount(
+	_ *gin.Context,
+	req *createAccountReq,
+) (*account, error) {
+	if !accountNamePattern.MatchString(req.Name) {
+		return nil, errInvalidAccount
+	}
+
+	if len(req.Password) < minPasswordLength ||
+		len(req.Password) > maxPasswordLength {
+		return nil, errInvalidPassword
+	}
+
+	count := int64(0)
+	if err := s.db.Model(&orm.Account{}).
+		Where("name = ?", req.Name).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errAccountExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(
+		[]byte(req.Password),
+		bcrypt.DefaultCost,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &orm.Account{
+		Name:         req.Name,
+		PasswordHash: string(hash),
+	}
+	// A concurrent request creating the same name is stopped by the
+	// unique key of the name.
+	if err := s.db.Model(&orm.Account{}).Create(a).Error; err != nil {
+		if isDuplicateKey(err) {
+			return nil, errAccountExists
+		}
+		return nil, err
+	}
+
+	return &account{
+		Name:      a.Name,
+		Timestamp: uint64(a.CreatedAt.Unix()),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/photo-storage/dropbox/api/pagination"
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	apiKeyIDSize     = 8
+	apiKeySecretSize = 32
+)
+
+// apiKeyScopes are the scopes an API key can be granted.
+var apiKeyScopes = []string{ScopeRead, ScopeWrite}
+
+type createAPIKeyReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+type apiKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Key is only returned on creation, it can not be recovered later.
+	Key        string `json:"key,omitempty"`
+	LastUsedAt uint64 `json:"last_used_at,omitempty"`
+	Timestamp  uint64 `json:"timestamp"`
+}
+
+// CreateAPIKey handles the POST /keys request.
+func (s *Service) CreateAPIKey(
+	c *gin.Context,
+	req *createAPIKeyReq,
+) (*apiKey, error) {
+	if len(req.Scopes) == 0 {
+		return nil, errInvalidScope
+	}
+
+	for _, scope := range req.Scopes {
+		if !contains(apiKeyScopes, scope) {
+			return nil, errInvalidScope
+		}
+	}
+
+	id := make([]byte, apiKeyIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, apiKeySecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	k := &orm.APIKey{
+		Account:    accountOf(c),
+		KeyID:      hex.EncodeToString(id),
+		SecretHash: hashSecret(hex.EncodeToString(secret)),
+		Name:       req.Name,
+		Scopes:     strings.Join(req.Scopes, " "),
+	}
+	if err := s.db.Model(&orm.APIKey{}).Create(k).Error; err != nil {
+		return nil, err
+	}
+
+	resp := toAPIKey(k)
+	resp.Key = k.KeyID + "." + hex.EncodeToString(secret)
+	return resp, nil
+}
+
+// APIKeys handles the GET /keys request.
+func (s *Service) APIKeys(
+	c *gin.Context,
+	page *pagination.Query,
+) (*pagination.Result, error) {
+	query := s.db.Model(&orm.APIKey{}).
+		Where("account = ?", accountOf(c)).
+		Session(&gorm.Session{})
+	keys := make([]*orm.APIKey, 0)
+	if err := query.
+		Offset(page.Start).
+		Limit(page.Limit).
+		Order("id desc").
+		Find(&keys).
+		Error; err != nil {
+		return nil, err
+	}
+
+	ks := make([]*apiKey, len(keys))
+	for i, k := range keys {
+		ks[i] = toAPIKey(k)
+	}
+
+	count := int64(0)
+	if err := query.Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	return &pagination.Result{
+		Data:  ks,
+		Total: count,
+	}, nil
+}
+
+// DeleteAPIKey handles the DELETE /keys/:id request, which revokes the
+// key.
+func (s *Service) DeleteAPIKey(c *gin.Context) error {
+	result := s.db.
+		Where("account = ? and key_id = ?", accountOf(c), c.Param("id")).
+		Delete(&orm.APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func toAPIKey(k *orm.APIKey) *apiKey {
+	return &apiKey{
+		ID:         k.KeyID,
+		Name:       k.Name,
+		Scopes:     strings.Fields(k.Scopes),
+		LastUsedAt: unixOf(k.LastUsedAt),
+		Timestamp:  uint64(k.CreatedAt.Unix()),
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	// PrincipalLabel is the gin context key of the authenticated
+	// principal.
+	PrincipalLabel = "principal"
+
+	// APIKeyHeader carries the API key of a request.
+	APIKeyHeader = "X-Dropbox-Api-Key"
+
+	// lastUsedInterval bounds how often the use of an API key is
+	// recorded, so that a busy key does not write on every request.
+	lastUsedInterval = time.Minute
+)
+
+// Scopes granted to a principal. Only the account credentials and the
+// tokens issued for them carry ScopeKeys, so that an API key can not
+// create keys with more scopes than its own.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeKeys  = "keys"
+)
+
+var accountScopes = []string{ScopeRead, ScopeWrite, ScopeKeys}
+
+// Principal is the authenticated caller of a request. Wallets logged
+// in with their key have a public key instead of an account.
+type Principal struct {
+	Account   string
+	PublicKey string
+	Scopes    []string
+}
+
+// HasScope reports whether the principal is granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// Authenticate binds the request to the principal of its credentials,
+// which are either an API key, a bearer token or HTTP basic account
+// credentials.
+func (s *Service) Authenticate(c *gin.Context) error {
+	var p *Principal
+	var err error
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		p, err = s.authenticateAPIKey(key)
+	} else if token, ok := bearerToken(c); ok {
+		p, err = s.authenticateToken(token)
+	} else if name, password, ok := c.Request.BasicAuth(); ok {
+		p, err = s.authenticatePassword(name, password)
+	} else {
+		err = errUnauthenticated
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(PrincipalLabel, p)
+	return nil
+}
+
+func (s *Service) authenticatePassword(
+	name string,
+	password string,
+) (*Principal, error) {
+	a := &orm.Account{}
+	if err := s.db.Model(&orm.Account{}).
+		Where("name = ?", name).
+		First(a).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errUnauthenticated
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(
+		[]byte(a.PasswordHash),
+		[]byte(password),
+	); err != nil {
+		return nil, errUnauthenticated
+	}
+
+	return &Principal{Account: a.Name, Scopes: accountScopes}, nil
+}
+
+func (s *Service) authenticateToken(token string) (*Principal, error) {
+	claims, err := parseToken(s.tokenSecret, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.PublicKey != "" {
+		return &Principal{
+			PublicKey: claims.PublicKey,
+			Scopes:    strings.Fields(claims.Scope),
+		}, nil
+	}
+
+	return &Principal{
+		Account: claims.Subject,
+		Scopes:  strings.Fields(claims.Scope),
+	}, nil
+}
+
+func (s *Service) authenticateAPIKey(key string) (*Principal, error) {
+	id, secret, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil, errUnauthenticated
+	}
+
+	k := &orm.APIKey{}
+	if err := s.db.Model(&orm.APIKey{}).
+		Where("key_id = ?", id).
+		First(k).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errUnauthenticated
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(
+		[]byte(hashSecret(secret)),
+		[]byte(k.SecretHash),
+	) != 1 {
+		return nil, errUnauthenticated
+	}
+
+	if now := time.Now(); k.LastUsedAt == nil ||
+		now.Sub(*k.LastUsedAt) >= lastUsedInterval {
+		if err := s.db.Model(&orm.APIKey{}).
+			Where("id = ?", k.ID).
+			UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &Principal{
+		Account: k.Account,
+		Scopes:  strings.Fields(k.Scopes),
+	}, nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	v := c.GetHeader("Authorization")
+	if len(v) < 7 || !strings.EqualFold(v[:7], "bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(v[7:]), true
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// CheckScope fails with errForbidden unless the principal of the
+// request is granted scope.
+func CheckScope(c *gin.Context, scope string) error {
+	if !PrincipalOf(c).HasScope(scope) {
+		return errForbidden
+	}
+
+	return nil
+}
+
+// PrincipalOf returns the authenticated principal of the request, it
+// is empty for requests not authenticated.
+func PrincipalOf(c *gin.Context) *Principal {
+	if v, ok := c.Get(PrincipalLabel); ok {
+		return v.(*Principal)
+	}
+
+	return &Principal{}
+}
+
+// accountOf returns the authenticated account of the request.
+func accountOf(c *gin.Context) string {
+	return PrincipalOf(c).Account
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+
+	"github.com/photon-storage/go-photon/crypto/codec"
+	"github.com/photon-storage/go-photon/crypto/sha256"
+	"github.com/photon-storage/go-photon/depot"
+	pbd "github.com/photon-storage/photon-proto/depot"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// objectMeta carries the depot metadata needed to fetch and verify
+// the chunks of an object. The decoder is built once per object and
+// shared by the download files verifying its chunks. The chunk size is
+// the one recorded with the object, zero when it is unknown.
+type objectMeta struct {
+	commitTxHash sha256.Hash
+	hash         sha256.Hash
+	encodedHash  sha256.Hash
+	status       *pbd.ObjectStatusResponse
+	decoder      codec.Decoder
+	chunkSize    uint64
+	depot        *depotClient
+}
+
+func (s *Service) objectMeta(
+	ctx context.Context,
+	o *orm.Object,
+) (*objectMeta, error) {
+	ohash, err := sha256.HashFromHex(o.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	commitTxHash, err := sha256.HashFromHex(o.CommitTxHash)
+	if err != nil {
+		return nil, err
+	}
+
+	ehash, err := sha256.HashFromHex(o.EncodedHash)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.depots.get(o.DepotPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	objResp, err := d.cli.ObjectStatus(ctx, &pbd.ObjectStatusRequest{
+		Hash:         ohash.Bytes(),
+		CommitTxHash: commitTxHash.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.depots.setHealthy(d, true)
+
+	if objResp.Status != pbd.ObjectStatus_READABLE {
+		return nil, errObjectNotReadable
+	}
+
+	decoder, err := codec.NewMultikey(objResp.Decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectMeta{
+		commitTxHash: commitTxHash,
+		hash:         ohash,
+		encodedHash:  ehash,
+		status:       objResp,
+		decoder:      decoder,
+		chunkSize:    o.ChunkSize,
+		depot:        d,
+	}, nil
+}
+
+func (m *objectMeta) newDownloadFile() (*depot.DownloadFile, error) {
+	return depot.NewDownloadFile(
+		m.commitTxHash,
+		m.hash,
+		m.encodedHash,
+		m.status.Size,
+		m.status.EncodedSize,
+		m.status.NumBlocks,
+		m.status.BlocksPerChunk,
+		m.status.NumChunks,
+		m.decoder,
+	)
+}
+
+// isPlainObject reports whether the object was stored without encoding,
+// in which case the blocks of each chunk carry the original bytes and
+// a byte window can be served from a subset of the chunks.
+func isPlainObject(o *orm.Object) bool {
+	return o.EncodedHash == o.Hash
+}
+
+// chunkData returns the original bytes carried by a chunk of a plain
+// object. The data of the last chunk may be padded beyond the object
+// size.
+func chunkData(chunk *pbd.Chunk) []byte {
+	size := 0
+	for _, b := range chunk.Blocks {
+		size += len(b.Data)
+	}
+
+	data := make([]byte, 0, size)
+	for _, b := range chunk.Blocks {
+		data = append(data, b.Data...)
+	}
+
+	return data
+}
+
+func (s *Service) downloadChunk(
+	ctx context.Context,
+	m *objectMeta,
+	i uint32,
+) (*pbd.Chunk, error) {
+	resp, err := m.depot.cli.DownloadChunk(ctx, &pbd.DownloadChunkRequest{
+		Hash:         m.hash.Bytes(),
+		CommitTxHash: m.commitTxHash.Bytes(),
+		Index:        i,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Chunk, nil
+}
+
+// fetchVerifiedChunk downloads a single chunk and verifies it against
+// the object hashes. A download file keeps every chunk set on it, so a
+// throwaway one sharing the decoder of the object is used for each
+// chunk, so that streamed chunks are not retained in memory.
+func (s *Service) fetchVerifiedChunk(
+	ctx context.Context,
+	m *objectMeta,
+	i uint32,
+) (*pbd.Chunk, error) {
+	chunk, err := s.downloadChunk(ctx, m, i)
+	if err != nil {
+		return nil, err
+	}
+
+	df, err := m.newDownloadFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := df.SetChunk(i, chunk); err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
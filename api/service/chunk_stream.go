@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	pbd "github.com/photon-storage/photon-proto/depot"
+)
+
+type fetchChunkFunc func(
+	ctx context.Context,
+	m *objectMeta,
+	i uint32,
+) (*pbd.Chunk, error)
+
+type chunkResult struct {
+	chunk *pbd.Chunk
+	err   error
+}
+
+// chunkStream fetches a contiguous run of chunks in parallel and
+// delivers them in order. At most the configured download concurrency
+// of chunks are fetched or buffered ahead of the consumer.
+type chunkStream struct {
+	ctx     context.Context
+	pending chan chan chunkResult
+	cancel  context.CancelFunc
+}
+
+func (s *Service) streamChunks(
+	ctx context.Context,
+	m *objectMeta,
+	first uint32,
+	last uint32,
+	fetch fetchChunkFunc,
+) *chunkStream {
+	ctx, cancel := context.WithCancel(ctx)
+	cs := &chunkStream{
+		ctx:     ctx,
+		pending: make(chan chan chunkResult, s.downloadCfg.Concurrency),
+		cancel:  cancel,
+	}
+
+	// Each chunk gets its own result channel, queued in index order.
+	// The bounded queue limits how far fetching runs ahead of the
+	// consumer, while the consumer still receives chunks in order.
+	go func() {
+		defer close(cs.pending)
+		for i := first; i <= last; i++ {
+			result := make(chan chunkResult, 1)
+			select {
+			case cs.pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(i uint32) {
+				var chunk *pbd.Chunk
+				err := retry(
+					ctx,
+					s.downloadCfg.MaxRetries,
+					s.downloadCfg.RetryDelay,
+					func() error {
+						var err error
+						chunk, err = fetch(ctx, m, i)
+						return err
+					},
+				)
+				result <- chunkResult{chunk: chunk, err: err}
+			}(i)
+		}
+	}()
+
+	return cs
+}
+
+// next returns the next chunk in order.
+func (cs *chunkStream) next() (*pbd.Chunk, error) {
+	result, ok := <-cs.pending
+	if !ok {
+		if err := cs.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	select {
+	case r := <-result:
+		return r.chunk, r.err
+	case <-cs.ctx.Done():
+		return nil, cs.ctx.Err()
+	}
+}
+
+// close stops the background fetching.
+func (cs *chunkStream) close() {
+	cs.cancel()
+}
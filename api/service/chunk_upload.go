@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/photon-storage/go-common/log"
+
+	"github.com/photon-storage/go-photon/crypto/sha256"
+	"github.com/photon-storage/go-photon/depot"
+	pbd "github.com/photon-storage/photon-proto/depot"
+)
+
+// commitContent describes the content committed by a commit tx.
+type commitContent interface {
+	OriginalHash() sha256.Hash
+	OriginalSize() uint64
+	EncodedHash() sha256.Hash
+	EncodedSize() uint64
+	NumBlocks() uint32
+	NumChunks() uint32
+}
+
+// chunkSource is committed content whose chunks are pushed to a depot
+// one at a time, bound to the commit tx set by SetTxHash. ChunkSize is
+// the number of content bytes carried by every chunk but the last.
+type chunkSource interface {
+	commitContent
+	ChunkSize() uint64
+	SetTxHash(h sha256.Hash)
+	chunk(ctx context.Context, i uint32) (*pbd.Chunk, error)
+}
+
+// fileChunks is the content of an upload file, held in memory.
+type fileChunks struct {
+	*depot.UploadFile
+}
+
+func (f fileChunks) ChunkSize() uint64 {
+	if f.NumChunks() == 0 {
+		return 0
+	}
+
+	return uint64(len(chunkData(f.GetChunk(0))))
+}
+
+func (f fileChunks) chunk(_ context.Context, i uint32) (*pbd.Chunk, error) {
+	return f.GetChunk(i), nil
+}
+
+// storedChunks is the content of an object stored on a depot. Its
+// chunks are fetched and verified as they are pushed, so that the
+// object is committed again without being held in memory.
+type storedChunks struct {
+	s      *Service
+	m      *objectMeta
+	txHash sha256.Hash
+}
+
+func (c *storedChunks) OriginalHash() sha256.Hash {
+	return c.m.hash
+}
+
+func (c *storedChunks) OriginalSize() uint64 {
+	return c.m.status.Size
+}
+
+func (c *storedChunks) EncodedHash() sha256.Hash {
+	return c.m.encodedHash
+}
+
+func (c *storedChunks) EncodedSize() uint64 {
+	return c.m.status.EncodedSize
+}
+
+func (c *storedChunks) NumBlocks() uint32 {
+	return c.m.status.NumBlocks
+}
+
+func (c *storedChunks) NumChunks() uint32 {
+	return c.m.status.NumChunks
+}
+
+func (c *storedChunks) ChunkSize() uint64 {
+	return c.m.chunkSize
+}
+
+func (c *storedChunks) SetTxHash(h sha256.Hash) {
+	c.txHash = h
+}
+
+func (c *storedChunks) chunk(
+	ctx context.Context,
+	i uint32,
+) (*pbd.Chunk, error) {
+	chunk, err := c.s.fetchVerifiedChunk(ctx, c.m, i)
+	if err != nil {
+		return nil, err
+	}
+
+	// A downloaded chunk carries the tx it was first committed with.
+	chunk.TxHash = c.txHash.Bytes()
+	return chunk, nil
+}
+
+// pushChunks uploads the chunks of src that are not marked in the
+// received set reported by the depot. A nil set pushes every chunk.
+// After each pass the set is reconciled with the depot and the chunks
+// it is still missing are pushed again, up to the configured number
+// of retries. The optional progress callback is invoked with the
+// number of chunks received by the depot after each successful push.
+func (s *Service) pushChunks(
+	d *depotClient,
+	src chunkSource,
+	txHash sha256.Hash,
+	received *pbd.BitSet,
+	progress func(uint32) error,
+) error {
+	for pass := 0; ; pass++ {
+		missing := make([]uint32, 0)
+		for i := uint32(0); i < src.NumChunks(); i++ {
+			if !bitSetHas(received, i) {
+				missing = append(missing, i)
+			}
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if pass > s.uploadCfg.MaxRetries {
+			return ErrChunkCountMismatch
+		}
+
+		if err := s.uploadChunks(
+			d,
+			src,
+			missing,
+			bitSetCount(received),
+			progress,
+		); err != nil {
+			return err
+		}
+
+		objResp := (*pbd.ObjectStatusResponse)(nil)
+		if err := retry(
+			s.ctx,
+			s.uploadCfg.MaxRetries,
+			s.uploadCfg.RetryDelay,
+			func() error {
+				var err error
+				objResp, err = d.cli.ObjectStatus(
+					s.ctx,
+					&pbd.ObjectStatusRequest{
+						Hash:         src.OriginalHash().Bytes(),
+						CommitTxHash: txHash.Bytes(),
+					},
+				)
+				return err
+			},
+		); err != nil {
+			return err
+		}
+		s.depots.setHealthy(d, true)
+
+		if objResp.Status == pbd.ObjectStatus_READABLE {
+			return nil
+		}
+		received = objResp.Received
+	}
+}
+
+// uploadChunks pushes the given chunks with the configured concurrency,
+// retrying each push failing with a transient error with backoff. A
+// chunk still failing transiently is left for the next reconcile pass
+// of pushChunks, any other error aborts the upload.
+func (s *Service) uploadChunks(
+	d *depotClient,
+	src chunkSource,
+	indices []uint32,
+	count uint32,
+	progress func(uint32) error,
+) error {
+	g, ctx := errgroup.WithContext(s.ctx)
+	g.SetLimit(s.uploadCfg.Concurrency)
+
+	mu := sync.Mutex{}
+	for _, i := range indices {
+		i := i
+		g.Go(func() error {
+			if err := retry(
+				ctx,
+				s.uploadCfg.MaxRetries,
+				s.uploadCfg.RetryDelay,
+				func() error {
+					chunk, err := src.chunk(ctx, i)
+					if err != nil {
+						return err
+					}
+
+					_, err = d.cli.UploadChunk(ctx, &pbd.UploadChunkRequest{
+						Chunk: chunk,
+					})
+					return err
+				},
+			); err != nil {
+				if isTransient(err) && ctx.Err() == nil {
+					log.Warn("push chunk failed, leave it to next pass",
+						"index", i,
+						"error", err,
+					)
+					return nil
+				}
+
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			count++
+			if progress != nil {
+				return progress(count)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
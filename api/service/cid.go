@@ -10,24 +10,25 @@ import (
 	"github.com/photon-storage/go-photon/crypto/sha256"
 	pbd "github.com/photon-storage/photon-proto/depot"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/database/orm"
 )
 
 type cidTask struct {
-	ctx      context.Context
-	db       *gorm.DB
-	depotCli pbd.DepotClient
+	ctx    context.Context
+	db     *gorm.DB
+	depots *depotPool
 }
 
 func newCIDTask(
 	ctx context.Context,
 	db *gorm.DB,
-	depotCli pbd.DepotClient,
+	depots *depotPool,
 ) *cidTask {
 	return &cidTask{
-		ctx:      ctx,
-		db:       db,
-		depotCli: depotCli,
+		ctx:    ctx,
+		db:     db,
+		depots: depots,
 	}
 }
 
@@ -37,9 +38,15 @@ func (c *cidTask) run() {
 	for {
 		select {
 		case <-ticker.C:
+			start := time.Now()
 			if err := c.fetchCID(); err != nil {
 				log.Error("fetch object cid failed", "error", err)
 			}
+			metrics.ObserveTask("cid", start)
+
+			if err := c.countBacklog(); err != nil {
+				log.Error("count cid backlog failed", "error", err)
+			}
 
 		case <-c.ctx.Done():
 			return
@@ -47,10 +54,27 @@ func (c *cidTask) run() {
 	}
 }
 
+// countBacklog updates the number of objects waiting for their CID.
+func (c *cidTask) countBacklog() error {
+	count := int64(0)
+	if err := c.db.Model(&orm.Object{}).
+		Where("cid = ? and ref_id = '' and status in (?,?)",
+			"",
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+		).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	metrics.CIDBacklog.Set(float64(count))
+	return nil
+}
+
 func (c *cidTask) fetchCID() error {
 	os := make([]*orm.Object, 0)
 	if err := c.db.Model(&orm.Object{}).
-		Where("cid = ? and status in (?,?)",
+		Where("cid = ? and ref_id = '' and status in (?,?)",
 			"",
 			orm.ObjectCommitted,
 			orm.ObjectFinalized,
@@ -72,20 +96,31 @@ func (c *cidTask) fetchCID() error {
 			return err
 		}
 
-		objResp, err := c.depotCli.ObjectStatus(c.ctx, &pbd.ObjectStatusRequest{
+		// Objects on an unavailable depot are retried later.
+		d, err := c.depots.get(o.DepotPublicKey)
+		if err != nil {
+			continue
+		}
+
+		objResp, err := d.cli.ObjectStatus(c.ctx, &pbd.ObjectStatusRequest{
 			Hash:         ohash.Bytes(),
 			CommitTxHash: commitTxHash.Bytes(),
 		})
+		if isTransient(err) {
+			continue
+		}
+
 		if err != nil {
 			return err
 		}
+		c.depots.setHealthy(d, true)
 
 		if len(objResp.Cid) == 0 {
 			continue
 		}
 
 		if err := c.db.Model(&orm.Object{}).
-			Where("id = ?", o.ID).
+			Where("commit_tx_hash = ?", o.CommitTxHash).
 			Update("cid", string(objResp.Cid)).
 			Error; err != nil {
 			return err
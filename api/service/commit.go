@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+)
+
+// commitParams are the storage economics of a commit transaction and
+// the number of depots the object is committed to.
+type commitParams struct {
+	Duration uint64
+	Fee      uint64
+	Pledge   uint64
+	GasPrice uint64
+	Replicas uint64
+}
+
+const commitHeaderPrefix = "X-Dropbox-"
+
+// commitParams resolves the commit parameters of a request. A value is
+// looked up by its form name with get and falls back to the configured
+// default when absent. Overrides must be within the configured bounds.
+func (s *Service) commitParams(get func(name string) string) (*commitParams, error) {
+	p := &commitParams{}
+	for _, f := range []struct {
+		name  string
+		bound Bound
+		value *uint64
+	}{
+		{"duration", s.commitCfg.Duration, &p.Duration},
+		{"fee", s.commitCfg.Fee, &p.Fee},
+		{"pledge", s.commitCfg.Pledge, &p.Pledge},
+		{"gas_price", s.commitCfg.GasPrice, &p.GasPrice},
+		{"replicas", s.commitCfg.Replicas, &p.Replicas},
+	} {
+		v := get(f.name)
+		if v == "" {
+			*f.value = *f.bound.Default
+			continue
+		}
+
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, errInvalidCommitParam
+		}
+
+		if n < *f.bound.Min || n > *f.bound.Max {
+			return nil, errCommitParamOutOfRange
+		}
+		*f.value = n
+	}
+
+	return p, nil
+}
+
+// commitHeader returns the request header overriding the commit
+// parameter of the given form name, e.g. X-Dropbox-Gas-Price.
+func commitHeader(name string) string {
+	parts := strings.Split(name, "_")
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+
+	return commitHeaderPrefix + strings.Join(parts, "-")
+}
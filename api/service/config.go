@@ -0,0 +1,242 @@
+package service
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config defines the configuration of the service.
+type Config struct {
+	NodeEndpoint   string   `yaml:"node_endpoint"`
+	DepotBootstrap []string `yaml:"depot_bootstrap"`
+	// DepotEndpoints are the rpc endpoints of the depots to use instead
+	// of discovering them, meant for private deployments.
+	DepotEndpoints []string `yaml:"depot_endpoints"`
+	// SessionDir is the local directory where the content of resumable
+	// upload sessions is staged before being pushed to the depot. It is
+	// not shared between nodes, all requests of a session must reach
+	// the node that created it.
+	SessionDir string `yaml:"session_dir"`
+	// NodeID names this node as the owner of the upload sessions it
+	// creates, only the owner resumes them. It must be stable across
+	// restarts and unique among nodes, the host name is used when
+	// absent.
+	NodeID     string           `yaml:"node_id"`
+	Download   TransferConfig   `yaml:"download"`
+	Upload     TransferConfig   `yaml:"upload"`
+	Keys       KeysConfig       `yaml:"keys"`
+	Commit     CommitConfig     `yaml:"commit"`
+	Encoding   EncodingConfig   `yaml:"encoding"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Quota      QuotaConfig      `yaml:"quota"`
+	// Dedup reuses a stored object of the same content by default
+	// instead of committing an upload again.
+	Dedup bool `yaml:"dedup"`
+	// SlotDuration is the duration of a chain slot, used to estimate
+	// when storage contracts expire.
+	SlotDuration time.Duration `yaml:"slot_duration"`
+}
+
+// CommitConfig defines the storage economics of commit transactions.
+type CommitConfig struct {
+	ChainID uint32 `yaml:"chain_id"`
+	// DeadlineMod rounds the tx deadline up to a multiple of it, the
+	// deadline is at least one period after the chain head.
+	DeadlineMod uint64 `yaml:"deadline_mod"`
+	Duration    Bound  `yaml:"duration"`
+	Fee         Bound  `yaml:"fee"`
+	Pledge      Bound  `yaml:"pledge"`
+	GasPrice    Bound  `yaml:"gas_price"`
+	// Replicas is the number of depots an object is committed to, each
+	// with its own commit transaction.
+	Replicas Bound `yaml:"replicas"`
+	// RenewBefore is the number of slots before the expiry of a storage
+	// contract at which objects to keep are renewed.
+	RenewBefore uint64 `yaml:"renew_before"`
+}
+
+// EncodingConfig defines the default encoding of uploads.
+type EncodingConfig struct {
+	// Scheme is the erasure coding, either none or reed_solomon.
+	Scheme string `yaml:"scheme"`
+	// DataShards and ParityShards are the default Reed-Solomon shards,
+	// requests may pick others as long as there are at most 256 shards
+	// in total.
+	DataShards   uint32 `yaml:"data_shards"`
+	ParityShards uint32 `yaml:"parity_shards"`
+	// SignBlocks signs every block with the owner key.
+	SignBlocks bool `yaml:"sign_blocks"`
+}
+
+// EncryptionConfig defines the encryption of uploads.
+type EncryptionConfig struct {
+	// MasterKeyFile holds the hex encoded key wrapping the data keys of
+	// objects uploaded without a user key.
+	MasterKeyFile string `yaml:"master_key_file"`
+	// Default encrypts uploads not asking for it explicitly.
+	Default bool `yaml:"default"`
+}
+
+// AuthConfig defines the bearer tokens issued to accounts.
+type AuthConfig struct {
+	// TokenSecretFile holds the hex encoded HMAC key signing the tokens,
+	// at least 32 bytes. It is required.
+	TokenSecretFile string `yaml:"token_secret_file"`
+	// TokenTTL is the lifetime of an issued token.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// QuotaConfig defines the limits of the uploads of an account, a zero
+// limit is unlimited.
+type QuotaConfig struct {
+	// MaxFileSize is the largest file accepted in bytes.
+	MaxFileSize uint64 `yaml:"max_file_size"`
+	// MaxBytes is the total size of the objects of an account in bytes.
+	MaxBytes uint64 `yaml:"max_bytes"`
+	// MaxObjects is the number of objects of an account.
+	MaxObjects uint64 `yaml:"max_objects"`
+}
+
+// Bound defines the default value of a commit parameter and the range
+// within which a request is allowed to override it. The fields are
+// pointers so that a configured zero is told apart from an absent value.
+type Bound struct {
+	Default *uint64 `yaml:"default"`
+	Min     *uint64 `yaml:"min"`
+	Max     *uint64 `yaml:"max"`
+}
+
+// KeysConfig defines where the commit transaction signing keys come
+// from. Keys are read from the keystore directory, each account gets a
+// key of its own.
+type KeysConfig struct {
+	// KeystoreDir is the directory of EIP-2335 BLS keystore files.
+	KeystoreDir string `yaml:"keystore_dir"`
+	// PasswordFile is the file holding the keystore password.
+	PasswordFile string `yaml:"password_file"`
+	// Interop signs with the deterministic interop keys shared by all
+	// accounts instead of the keystore. Anyone can derive those keys,
+	// it is refused on any network other than devnet.
+	Interop bool `yaml:"interop"`
+}
+
+// TransferConfig defines how chunks are transferred from or to the
+// depot.
+type TransferConfig struct {
+	// Concurrency is the number of chunks transferred in parallel.
+	Concurrency int `yaml:"concurrency"`
+	// MaxRetries is the number of retries for a failed chunk transfer.
+	MaxRetries int `yaml:"max_retries"`
+	// RetryDelay is the initial backoff delay, doubled on each retry.
+	RetryDelay time.Duration `yaml:"retry_delay"`
+}
+
+func (c Config) slotDuration() time.Duration {
+	if c.SlotDuration <= 0 {
+		return 12 * time.Second
+	}
+
+	return c.SlotDuration
+}
+
+func (c Config) nodeID() (string, error) {
+	if c.NodeID != "" {
+		return c.NodeID, nil
+	}
+
+	return os.Hostname()
+}
+
+func (c CommitConfig) withDefaults() CommitConfig {
+	if c.ChainID == 0 {
+		c.ChainID = 1
+	}
+
+	if c.DeadlineMod == 0 {
+		c.DeadlineMod = 300
+	}
+
+	c.Duration = c.Duration.withDefault(10000)
+	c.Fee = c.Fee.withDefault(1)
+	c.Pledge = c.Pledge.withDefault(1)
+	c.GasPrice = c.GasPrice.withDefault(1)
+	c.Replicas = c.Replicas.withDefault(1)
+	if c.RenewBefore == 0 {
+		c.RenewBefore = 1000
+	}
+
+	return c
+}
+
+// withDefault fills the default value when absent and widens the bounds
+// to include it. Bounds left absent disable overriding. All the fields
+// of the returned bound are set.
+func (b Bound) withDefault(v uint64) Bound {
+	if b.Default == nil {
+		b.Default = &v
+	}
+
+	def := *b.Default
+	if b.Min == nil || *b.Min > def {
+		b.Min = &def
+	}
+
+	if b.Max == nil || *b.Max < def {
+		b.Max = &def
+	}
+
+	return b
+}
+
+func (c AuthConfig) withDefaults() AuthConfig {
+	if c.TokenTTL <= 0 {
+		c.TokenTTL = 24 * time.Hour
+	}
+
+	return c
+}
+
+func (c EncodingConfig) withDefaults() EncodingConfig {
+	if c.Scheme == "" {
+		c.Scheme = encodingNone
+	}
+
+	if c.DataShards == 0 {
+		c.DataShards = 4
+	}
+
+	if c.ParityShards == 0 {
+		c.ParityShards = 2
+	}
+
+	return c
+}
+
+func (c EncodingConfig) validate() error {
+	return errors.Wrapf(
+		validateEncoding(c.Scheme, c.DataShards, c.ParityShards),
+		"encoding: %s %d+%d",
+		c.Scheme,
+		c.DataShards,
+		c.ParityShards,
+	)
+}
+
+func (c TransferConfig) withDefaults() TransferConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 4
+	}
+
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = 500 * time.Millisecond
+	}
+
+	return c
+}
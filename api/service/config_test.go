@@ -0,0 +1,41 @@
+package service
+
+import "testing"
+
+func TestBoundWithDefault(t *testing.T) {
+	u := func(v uint64) *uint64 { return &v }
+	cases := []struct {
+		name  string
+		bound Bound
+		def   uint64
+		min   uint64
+		max   uint64
+	}{
+		{name: "absent", bound: Bound{}, def: 10, min: 10, max: 10},
+		{name: "zero default", bound: Bound{Default: u(0)}, def: 0},
+		{
+			name:  "zero min",
+			bound: Bound{Min: u(0), Max: u(100)},
+			def:   10,
+			min:   0,
+			max:   100,
+		},
+		{
+			name:  "widened to default",
+			bound: Bound{Default: u(50), Min: u(60), Max: u(40)},
+			def:   50,
+			min:   50,
+			max:   50,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := tc.bound.withDefault(10)
+			if *b.Default != tc.def || *b.Min != tc.min || *b.Max != tc.max {
+				t.Fatalf("bound = %d [%d, %d], want %d [%d, %d]",
+					*b.Default, *b.Min, *b.Max, tc.def, tc.min, tc.max)
+			}
+		})
+	}
+}
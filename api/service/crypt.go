@@ -0,0 +1,358 @@
+package service
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	// Objects are encrypted in segments so that they can be streamed,
+	// every segment is sealed with AES-256-GCM under the data key.
+	encryptionAlgorithm   = "aes-256-gcm-64k"
+	encryptionSegmentSize = 64 << 10
+	encryptionKeySize     = 32
+
+	keySourceMaster = "master"
+	keySourceUser   = "user"
+)
+
+// objectEncryption carries the data key of an object being uploaded.
+type objectEncryption struct {
+	dataKey    []byte
+	wrappedKey []byte
+	keySource  string
+}
+
+// uploadEncryption resolves the encryption of a request. Values are
+// looked up by their form name with get. The data key is wrapped by the
+// user key if one is given, by the master key otherwise. It returns nil
+// when the upload is not encrypted.
+func (s *Service) uploadEncryption(
+	get func(name string) string,
+) (*objectEncryption, error) {
+	encrypt := s.encryptionCfg.Default
+	if v := get("encrypt"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errInvalidEncryption
+		}
+		encrypt = b
+	}
+
+	if !encrypt {
+		return nil, nil
+	}
+
+	kek, source := s.masterKey, keySourceMaster
+	if v := get("encryption_key"); v != "" {
+		k, err := parseEncryptionKey(v)
+		if err != nil {
+			return nil, err
+		}
+		kek, source = k, keySourceUser
+	}
+
+	if kek == nil {
+		return nil, errEncryptionNotConfigured
+	}
+
+	dk := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapKey(kek, dk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectEncryption{
+		dataKey:    dk,
+		wrappedKey: wrapped,
+		keySource:  source,
+	}, nil
+}
+
+// saveObjectKey records the wrapped data key of the content hash
+// unless it is already recorded.
+func (s *Service) saveObjectKey(
+	hash string,
+	e *objectEncryption,
+	plainSize uint64,
+) error {
+	return s.db.Model(&orm.ObjectKey{}).
+		Where("hash = ?", hash).
+		Attrs(&orm.ObjectKey{
+			Hash:       hash,
+			WrappedKey: e.wrappedKey,
+			KeySource:  e.keySource,
+			Algorithm:  encryptionAlgorithm,
+			PlainSize:  plainSize,
+		}).
+		FirstOrCreate(&orm.ObjectKey{}).
+		Error
+}
+
+// objectKey returns the key of an encrypted object and unwraps its data
+// key, userKey is the hex encoded key given by the client if any. It
+// returns nil when the object is not encrypted.
+func (s *Service) objectKey(
+	o *orm.Object,
+	userKey string,
+) (*orm.ObjectKey, []byte, error) {
+	ok := &orm.ObjectKey{}
+	if err := s.db.Model(&orm.ObjectKey{}).
+		Where("hash = ?", o.Hash).
+		First(ok).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	kek := s.masterKey
+	if ok.KeySource == keySourceUser {
+		if userKey == "" {
+			return nil, nil, errEncryptionKeyRequired
+		}
+
+		k, err := parseEncryptionKey(userKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		kek = k
+	}
+
+	if kek == nil {
+		return nil, nil, errEncryptionNotConfigured
+	}
+
+	dk, err := unwrapKey(kek, ok.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ok, dk, nil
+}
+
+func loadMasterKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read master key failed")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, errors.New("master key must be 32 hex encoded bytes")
+	}
+
+	return key, nil
+}
+
+func parseEncryptionKey(v string) ([]byte, error) {
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, errInvalidEncryptionKey
+	}
+
+	return key, nil
+}
+
+// wrapKey seals the data key with the key encryption key, the random
+// nonce is prepended to the result.
+func wrapKey(kek []byte, dk []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dk, nil), nil
+}
+
+func unwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errInvalidEncryptionKey
+	}
+
+	n := aead.NonceSize()
+	dk, err := aead.Open(nil, wrapped[:n], wrapped[n:], nil)
+	if err != nil {
+		return nil, errInvalidEncryptionKey
+	}
+
+	return dk, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce derives the nonce of a segment from its index, which is
+// safe as every object has its own data key. The additional data marks
+// the last segment so that truncated content fails to decrypt.
+func segmentNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+func segmentAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+// encryptReader encrypts the content read from src.
+type encryptReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	plain []byte
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+func newEncryptReader(src io.Reader, dk []byte) (*encryptReader, error) {
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{
+		aead:  aead,
+		src:   bufio.NewReader(src),
+		plain: make([]byte, encryptionSegmentSize),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *encryptReader) seal() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	last := false
+	switch err {
+	case nil:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+
+	default:
+		return err
+	}
+
+	r.buf = r.aead.Seal(
+		r.buf[:0],
+		segmentNonce(r.aead, r.index),
+		r.plain[:n],
+		segmentAD(last),
+	)
+	r.index++
+	r.done = last
+	return nil
+}
+
+// decryptWriter decrypts the content written to it into w. Close must
+// be called to decrypt the last segment.
+type decryptWriter struct {
+	aead  cipher.AEAD
+	w     io.Writer
+	buf   []byte
+	index uint64
+}
+
+func newDecryptWriter(w io.Writer, dk []byte) (*decryptWriter, error) {
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptWriter{aead: aead, w: w}, nil
+}
+
+func (w *decryptWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	size := encryptionSegmentSize + w.aead.Overhead()
+	// A full segment is kept until more content shows it is not the
+	// last one.
+	for len(w.buf) > size {
+		if err := w.open(w.buf[:size], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[size:]
+	}
+
+	return len(p), nil
+}
+
+func (w *decryptWriter) Close() error {
+	return w.open(w.buf, true)
+}
+
+func (w *decryptWriter) open(segment []byte, last bool) error {
+	plain, err := w.aead.Open(
+		nil,
+		segmentNonce(w.aead, w.index),
+		segment,
+		segmentAD(last),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "decrypt segment %d", w.index)
+	}
+	w.index++
+
+	_, err = w.w.Write(plain)
+	return err
+}
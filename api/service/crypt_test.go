@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	segment := encryptionSegmentSize + 16
+	cases := []struct {
+		name string
+		size int
+		// tamper changes the encrypted content, decryption must fail
+		// when it is set.
+		tamper func([]byte) []byte
+	}{
+		{name: "empty", size: 0},
+		{name: "one byte", size: 1},
+		{name: "below segment", size: encryptionSegmentSize - 1},
+		{name: "one segment", size: encryptionSegmentSize},
+		{name: "above segment", size: encryptionSegmentSize + 1},
+		{name: "several segments", size: 3*encryptionSegmentSize + 7},
+		{
+			name: "flipped byte",
+			size: 100,
+			tamper: func(b []byte) []byte {
+				b[10] ^= 1
+				return b
+			},
+		},
+		{
+			name: "truncated segment",
+			size: 100,
+			tamper: func(b []byte) []byte {
+				return b[:len(b)-1]
+			},
+		},
+		{
+			name: "dropped last segment",
+			size: 2 * encryptionSegmentSize,
+			tamper: func(b []byte) []byte {
+				return b[:segment]
+			},
+		},
+		{
+			name: "swapped segments",
+			size: 2*encryptionSegmentSize + 1,
+			tamper: func(b []byte) []byte {
+				out := append([]byte{}, b[segment:2*segment]...)
+				out = append(out, b[:segment]...)
+				return append(out, b[2*segment:]...)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dk := make([]byte, 32)
+			plain := make([]byte, tc.size)
+			if _, err := rand.Read(dk); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rand.Read(plain); err != nil {
+				t.Fatal(err)
+			}
+
+			er, err := newEncryptReader(bytes.NewReader(plain), dk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := io.ReadAll(er)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				sealed = tc.tamper(sealed)
+			}
+
+			// Write in odd sized pieces to cross the segment boundaries.
+			out := &bytes.Buffer{}
+			dw, err := newDecryptWriter(out, dk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for b := sealed; len(b) > 0 && err == nil; {
+				n := 1000
+				if n > len(b) {
+					n = len(b)
+				}
+				_, err = dw.Write(b[:n])
+				b = b[n:]
+			}
+			if err == nil {
+				err = dw.Close()
+			}
+
+			if tc.tamper != nil {
+				if err == nil {
+					t.Fatal("tampered content decrypted")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), plain) {
+				t.Fatal("decrypted content differs")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-photon/depot"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// dedupRequested tells whether a request opts in to deduplication, the
+// value is looked up by its form name with get.
+func (s *Service) dedupRequested(get func(name string) string) (bool, error) {
+	v := get("dedup")
+	if v == "" {
+		return s.dedup, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errInvalidDedup
+	}
+
+	return b, nil
+}
+
+// findDuplicate returns a finalized object of the account storing the
+// content of uf with the same encoding, whose storage contract is kept
+// or lasts at least the requested duration. It returns nil if there is
+// none. Objects of other accounts are never matched so that uploads do
+// not reveal whether some content is stored.
+func (s *Service) findDuplicate(
+	account string,
+	pk string,
+	uf *depot.UploadFile,
+	params *commitParams,
+	enc *uploadEncoding,
+) (*orm.Object, error) {
+	head, err := s.nodeCli.GetChainHead(s.ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+
+	o := &orm.Object{}
+	if err := s.db.Model(&orm.Object{}).
+		Where(
+			"account = ? and hash = ? and owner_public_key = ? and status = ?",
+			account,
+			uf.OriginalHash().Hex(),
+			pk,
+			orm.ObjectFinalized,
+		).
+		Where("ref_id = '' and replica_of = ''").
+		Where(
+			"encoding = ? and sign_blocks = ?",
+			enc.Scheme,
+			enc.SignBlocks,
+		).
+		Where(
+			"(encoding = ? or (data_shards = ? and parity_shards = ?))",
+			encodingNone,
+			enc.DataShards,
+			enc.ParityShards,
+		).
+		Where(
+			"(retention = ? or expiry_slot >= ?)",
+			orm.RetentionKeep,
+			uint64(head.HeadSlot)+params.Duration,
+		).
+		Order("id desc").
+		First(o).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// insertDuplicate creates a logical entry at place for the content
+// stored by o. The entry shares the commit tx of o and is told apart by
+// its ref id.
+func (s *Service) insertDuplicate(place *objectPlace, o *orm.Object) error {
+	ref := make([]byte, 16)
+	if _, err := rand.Read(ref); err != nil {
+		return err
+	}
+
+	dup := *o
+	dup.ID = 0
+	dup.Account = place.account
+	dup.DirectoryID = place.dirID
+	dup.Name = place.name
+	dup.RefID = hex.EncodeToString(ref)
+	dup.RenewalOf = ""
+	dup.RenewedBy = ""
+	dup.CreatedAt = time.Time{}
+	dup.UpdatedAt = time.Time{}
+	return s.insertObject(place, 0, &dup)
+}
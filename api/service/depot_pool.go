@@ -0,0 +1,277 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-photon/p2p"
+	pbd "github.com/photon-storage/photon-proto/depot"
+)
+
+const (
+	depotHealthInterval = 10 * time.Second
+	depotHealthTimeout  = 5 * time.Second
+)
+
+// depotClient is a dialed depot identified by its public key.
+type depotClient struct {
+	pk          []byte
+	discoveryID []byte
+	endpoint    string
+	cli         pbd.DepotClient
+}
+
+func (d *depotClient) publicKey() string {
+	return hex.EncodeToString(d.pk)
+}
+
+// depotPool keeps the depots discovered on the p2p network along with
+// their health. Uploads are spread over the healthy depots in round
+// robin, downloads go to the depot holding the object. When static
+// endpoints are configured, discovery is disabled and only those
+// depots are used.
+type depotPool struct {
+	ctx       context.Context
+	bootstrap []string
+	static    []string
+
+	mu        sync.RWMutex
+	depots    map[string]*depotClient
+	healthy   map[string]bool
+	order     []string
+	endpoints map[string]bool
+	next      int
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func newDepotPool(
+	ctx context.Context,
+	bootstrap []string,
+	static []string,
+) *depotPool {
+	return &depotPool{
+		ctx:       ctx,
+		bootstrap: bootstrap,
+		static:    static,
+		depots:    make(map[string]*depotClient),
+		healthy:   make(map[string]bool),
+		endpoints: make(map[string]bool),
+		ready:     make(chan struct{}),
+	}
+}
+
+// run starts discovering depots and checking their health.
+func (p *depotPool) run() error {
+	if len(p.static) > 0 {
+		p.connectStatic()
+	} else {
+		pf, err := newPeerFinder(p.ctx, p.bootstrap)
+		if err != nil {
+			return err
+		}
+
+		go pf.Run(p.discovered)
+	}
+
+	go p.checkHealth()
+	return nil
+}
+
+// wait blocks until the first depot is added to the pool.
+func (p *depotPool) wait(timeout time.Duration) error {
+	select {
+	case <-p.ready:
+		return nil
+	case <-time.After(timeout):
+		return ErrTimeout
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+func (p *depotPool) discovered(n *enode.Node) bool {
+	if p.ctx.Err() != nil {
+		return true
+	}
+
+	var role p2p.Role
+	if err := n.Load(&role); err != nil {
+		log.Error("Load role", "err", err)
+		return false
+	}
+
+	if role != p2p.RoleDepot {
+		time.Sleep(10 * time.Millisecond)
+		return false
+	}
+
+	endpoint, err := depotEndpoint(n)
+	if err != nil {
+		log.Error("resolve depot endpoint failed", "node", n.ID(), "error", err)
+		return false
+	}
+
+	p.connect(endpoint)
+	return false
+}
+
+// connectStatic connects the configured endpoints not in the pool yet.
+func (p *depotPool) connectStatic() {
+	for _, endpoint := range p.static {
+		p.connect(endpoint)
+	}
+}
+
+// connect adds the depot at endpoint in the background unless it is
+// already known.
+func (p *depotPool) connect(endpoint string) {
+	p.mu.Lock()
+	known := p.endpoints[endpoint]
+	p.endpoints[endpoint] = true
+	p.mu.Unlock()
+	if known {
+		return
+	}
+
+	go func() {
+		if err := p.add(endpoint); err != nil {
+			log.Error("add depot failed", "endpoint", endpoint, "error", err)
+			p.mu.Lock()
+			delete(p.endpoints, endpoint)
+			p.mu.Unlock()
+		}
+	}()
+}
+
+func (p *depotPool) add(endpoint string) error {
+	conn, err := rpcDialConfig(endpoint).Dial(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	cli := meteredDepotClient{pbd.NewDepotClient(conn)}
+	ctx, cancel := context.WithTimeout(p.ctx, depotHealthTimeout)
+	defer cancel()
+	state, err := cli.State(ctx, &emptypb.Empty{})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	d := &depotClient{
+		pk:          state.GetPublicKey(),
+		discoveryID: state.GetDiscoveryId(),
+		endpoint:    endpoint,
+		cli:         cli,
+	}
+	pk := d.publicKey()
+
+	p.mu.Lock()
+	if _, ok := p.depots[pk]; !ok {
+		p.order = append(p.order, pk)
+	}
+	p.depots[pk] = d
+	p.healthy[pk] = true
+	p.mu.Unlock()
+
+	log.Info("depot added", "public_key", pk, "endpoint", endpoint)
+	p.readyOnce.Do(func() { close(p.ready) })
+	return nil
+}
+
+func (p *depotPool) checkHealth() {
+	ticker := time.NewTicker(depotHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.RLock()
+			depots := make([]*depotClient, 0, len(p.depots))
+			for _, d := range p.depots {
+				depots = append(depots, d)
+			}
+			p.mu.RUnlock()
+
+			for _, d := range depots {
+				p.setHealthy(d, p.probe(d))
+			}
+
+			// Static depots down at startup are retried.
+			p.connectStatic()
+
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *depotPool) probe(d *depotClient) bool {
+	ctx, cancel := context.WithTimeout(p.ctx, depotHealthTimeout)
+	defer cancel()
+	state, err := d.cli.State(ctx, &emptypb.Empty{})
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(state.GetPublicKey(), d.pk)
+}
+
+func (p *depotPool) setHealthy(d *depotClient, healthy bool) {
+	pk := d.publicKey()
+	p.mu.Lock()
+	changed := p.healthy[pk] != healthy
+	p.healthy[pk] = healthy
+	p.mu.Unlock()
+
+	if changed {
+		log.Info("depot health changed", "public_key", pk, "healthy", healthy)
+	}
+}
+
+// pick returns the next healthy depot in round robin, skipping the
+// depots of the excluded public keys.
+func (p *depotPool) pick(exclude ...string) (*depotClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < len(p.order); i++ {
+		pk := p.order[(p.next+i)%len(p.order)]
+		if p.healthy[pk] && !contains(exclude, pk) {
+			p.next = (p.next + i + 1) % len(p.order)
+			return p.depots[pk], nil
+		}
+	}
+
+	return nil, errNoDepotAvailable
+}
+
+// get returns the depot of the hex encoded public key. A depot recorded
+// as unhealthy is still returned since it holds the objects asked for,
+// the caller marks it healthy again once a call to it succeeds.
+func (p *depotPool) get(pk string) (*depotClient, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	d, ok := p.depots[pk]
+	if !ok {
+		return nil, errDepotUnavailable
+	}
+
+	return d, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pbd "github.com/photon-storage/photon-proto/depot"
+)
+
+// stateDepotClient answers the state requests of the health checks.
+type stateDepotClient struct {
+	pbd.DepotClient
+	pk  []byte
+	err error
+}
+
+func (c *stateDepotClient) State(
+	context.Context,
+	*emptypb.Empty,
+	...grpc.CallOption,
+) (*pbd.StateResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &pbd.StateResponse{PublicKey: c.pk}, nil
+}
+
+// newTestPool returns a pool of healthy depots with the given keys.
+func newTestPool(keys ...string) *depotPool {
+	p := newDepotPool(context.Background(), nil, nil)
+	for _, k := range keys {
+		d := &depotClient{pk: []byte(k), endpoint: k}
+		p.depots[d.publicKey()] = d
+		p.healthy[d.publicKey()] = true
+		p.order = append(p.order, d.publicKey())
+	}
+
+	return p
+}
+
+func depotOf(t *testing.T, p *depotPool, key string) *depotClient {
+	d, err := p.get(hex.EncodeToString([]byte(key)))
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+
+	return d
+}
+
+func pickKeys(t *testing.T, p *depotPool, n int, exclude ...string) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		d, err := p.pick(exclude...)
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		keys[i] = string(d.pk)
+	}
+
+	return keys
+}
+
+func TestDepotPoolFailover(t *testing.T) {
+	p := newTestPool("a", "b", "c")
+	if got := pickKeys(t, p, 4); got[0] != "a" || got[1] != "b" ||
+		got[2] != "c" || got[3] != "a" {
+		t.Fatalf("healthy picks = %q, want [a b c a]", got)
+	}
+
+	// Uploads move over to the depots left healthy.
+	b := depotOf(t, p, "b")
+	p.setHealthy(b, false)
+	for _, k := range pickKeys(t, p, 4) {
+		if k == "b" {
+			t.Fatal("picked the unhealthy depot")
+		}
+	}
+
+	// Downloads still reach the objects held by the unhealthy depot.
+	if d, err := p.get(b.publicKey()); err != nil || d != b {
+		t.Fatalf("get unhealthy depot: got (%v, %v), want (%v, nil)", d, err, b)
+	}
+
+	// A replica excludes the depots holding a copy.
+	a := depotOf(t, p, "a")
+	if got := pickKeys(t, p, 2, a.publicKey()); got[0] != "c" ||
+		got[1] != "c" {
+		t.Fatalf("picks excluding a = %q, want [c c]", got)
+	}
+
+	p.setHealthy(depotOf(t, p, "c"), false)
+	if _, err := p.pick(a.publicKey()); err != errNoDepotAvailable {
+		t.Fatalf("pick without depot left: got %v, want %v",
+			err,
+			errNoDepotAvailable,
+		)
+	}
+
+	// A depot back in health takes uploads again.
+	p.setHealthy(b, true)
+	if got := pickKeys(t, p, 1, a.publicKey()); got[0] != "b" {
+		t.Fatalf("pick after recovery = %q, want [b]", got)
+	}
+
+	if _, err := p.get(hex.EncodeToString([]byte("d"))); err != errDepotUnavailable {
+		t.Fatalf("get unknown depot: got %v, want %v", err, errDepotUnavailable)
+	}
+}
+
+func TestDepotPoolProbe(t *testing.T) {
+	p := newTestPool("a")
+	d := depotOf(t, p, "a")
+
+	d.cli = &stateDepotClient{pk: []byte("a")}
+	if !p.probe(d) {
+		t.Fatal("probe of an answering depot failed")
+	}
+
+	d.cli = &stateDepotClient{err: errors.New("connection refused")}
+	if p.probe(d) {
+		t.Fatal("probe of a failing depot succeeded")
+	}
+
+	// Another depot now answering at the endpoint does not hold the
+	// objects of this one.
+	d.cli = &stateDepotClient{pk: []byte("b")}
+	if p.probe(d) {
+		t.Fatal("probe of a replaced depot succeeded")
+	}
+}
@@ -0,0 +1,524 @@
+package service
+
+import (
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/photo-storage/dropbox/api/pagination"
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const maxPathNameLength = 255
+
+// objectPlace is where an object is listed in the folders of an account.
+type objectPlace struct {
+	account string
+	dirID   uint64
+	name    string
+}
+
+func placeOf(o *orm.Object) *objectPlace {
+	return &objectPlace{
+		account: o.Account,
+		dirID:   o.DirectoryID,
+		name:    o.Name,
+	}
+}
+
+type createDirectoryReq struct {
+	Path string `json:"path" binding:"required"`
+}
+
+type moveReq struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
+
+type directory struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+type entry struct {
+	Type      string     `json:"type"`
+	Directory *directory `json:"directory,omitempty"`
+	Object    *object    `json:"object,omitempty"`
+}
+
+// CreateDirectory handles the POST /directories request, the missing
+// parents of the path are created as well.
+func (s *Service) CreateDirectory(
+	c *gin.Context,
+	req *createDirectoryReq,
+) (*directory, error) {
+	names, err := splitPath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, errInvalidPath
+	}
+
+	account := accountOf(c)
+	parentID, err := s.makeDirs(account, names[:len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	d := &orm.Directory{
+		Account:  account,
+		ParentID: parentID,
+		Name:     names[len(names)-1],
+	}
+	place := &objectPlace{account: account, dirID: parentID, name: d.Name}
+	if err := s.claimPlace(place, func(tx *gorm.DB) error {
+		return tx.Model(&orm.Directory{}).Create(d).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	return toDirectory(d), nil
+}
+
+// Directory handles the GET /directories request listing the folders
+// followed by the objects in the folder of the given path.
+func (s *Service) Directory(
+	c *gin.Context,
+	page *pagination.Query,
+) (*pagination.Result, error) {
+	names, err := splitPath(c.DefaultQuery("path", "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := requireAccount(c)
+	if err != nil {
+		return nil, err
+	}
+
+	dirID, err := s.lookupDir(account, names)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := s.db.Model(&orm.Directory{}).
+		Where("account = ? and parent_id = ?", account, dirID).
+		Session(&gorm.Session{})
+	objs := s.visibleObjects(account, dirID).Session(&gorm.Session{})
+
+	numDirs := int64(0)
+	if err := dirs.Count(&numDirs).Error; err != nil {
+		return nil, err
+	}
+
+	numObjs := int64(0)
+	if err := objs.Count(&numObjs).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]*entry, 0, page.Limit)
+	if int64(page.Start) < numDirs {
+		ds := make([]*orm.Directory, 0)
+		if err := dirs.
+			Offset(page.Start).
+			Limit(page.Limit).
+			Order("name").
+			Find(&ds).
+			Error; err != nil {
+			return nil, err
+		}
+
+		for _, d := range ds {
+			entries = append(entries, &entry{
+				Type:      "directory",
+				Directory: toDirectory(d),
+			})
+		}
+	}
+
+	if left := page.Limit - len(entries); left > 0 {
+		offset := int64(page.Start) - numDirs
+		if offset < 0 {
+			offset = 0
+		}
+
+		os := make([]*orm.Object, 0)
+		if err := objs.
+			Offset(int(offset)).
+			Limit(left).
+			Order("name").
+			Find(&os).
+			Error; err != nil {
+			return nil, err
+		}
+
+		for _, o := range os {
+			entries = append(entries, &entry{
+				Type:   "object",
+				Object: toObject(o),
+			})
+		}
+	}
+
+	return &pagination.Result{
+		Data:  entries,
+		Total: numDirs + numObjs,
+	}, nil
+}
+
+// MoveDirectory handles the POST /directories/move request, which moves
+// or renames a folder along with its content.
+func (s *Service) MoveDirectory(c *gin.Context, req *moveReq) (*directory, error) {
+	from, err := splitPath(req.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := splitPath(req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(from) == 0 || len(to) == 0 {
+		return nil, errInvalidPath
+	}
+
+	account := accountOf(c)
+	srcID, err := s.lookupDir(account, from)
+	if err != nil {
+		return nil, err
+	}
+
+	parentID, err := s.lookupDir(account, to[:len(to)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// A folder can not be moved into its own subtree.
+	for id := parentID; id != 0; {
+		if id == srcID {
+			return nil, errMoveIntoSelf
+		}
+
+		d := &orm.Directory{}
+		if err := s.db.Model(&orm.Directory{}).
+			Where("id = ?", id).
+			First(d).Error; err != nil {
+			return nil, err
+		}
+		id = d.ParentID
+	}
+
+	name := to[len(to)-1]
+	place := &objectPlace{account: account, dirID: parentID, name: name}
+	if err := s.claimPlace(place, func(tx *gorm.DB) error {
+		return tx.Model(&orm.Directory{}).
+			Where("id = ?", srcID).
+			Updates(map[string]any{
+				"parent_id": parentID,
+				"name":      name,
+			}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	d := &orm.Directory{}
+	if err := s.db.Model(&orm.Directory{}).
+		Where("id = ?", srcID).
+		First(d).Error; err != nil {
+		return nil, err
+	}
+
+	return toDirectory(d), nil
+}
+
+// MoveObject handles the POST /objects/move request, which moves or
+// renames the object at a path.
+func (s *Service) MoveObject(c *gin.Context, req *moveReq) (*object, error) {
+	account := accountOf(c)
+	o, err := s.findObjectByPath(account, req.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := s.resolvePlace(account, req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every copy of the object moves along. The replicas belong to the
+	// stored object, not to the duplicate entries sharing its commit tx.
+	if err := s.claimPlace(to, func(tx *gorm.DB) error {
+		q := tx.Model(&orm.Object{}).Where("id = ?", o.ID)
+		if o.RefID == "" {
+			q = q.Or("replica_of = ? and ref_id = ''", o.CommitTxHash)
+		}
+
+		return q.Updates(map[string]any{
+			"directory_id": to.dirID,
+			"name":         to.name,
+		}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	o.DirectoryID = to.dirID
+	o.Name = to.name
+	return toObject(o), nil
+}
+
+// resolvePlace returns the place of the object path, its folder must
+// exist.
+func (s *Service) resolvePlace(account string, p string) (*objectPlace, error) {
+	names, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, errInvalidPath
+	}
+
+	dirID, err := s.lookupDir(account, names[:len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectPlace{
+		account: account,
+		dirID:   dirID,
+		name:    names[len(names)-1],
+	}, nil
+}
+
+// uploadPlace returns the place of an upload at path p, or of the
+// given name in the top level folder if p is empty. The missing folders
+// of p are created, the place itself must not be taken.
+func (s *Service) uploadPlace(
+	account string,
+	p string,
+	name string,
+) (*objectPlace, error) {
+	place := &objectPlace{account: account, name: name}
+	if p == "" && !validName(name) {
+		return nil, errInvalidPath
+	}
+
+	if p != "" {
+		names, err := splitPath(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(names) == 0 {
+			return nil, errInvalidPath
+		}
+
+		dirID, err := s.makeDirs(account, names[:len(names)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		place.dirID = dirID
+		place.name = names[len(names)-1]
+	}
+
+	if err := checkPathFree(s.db, place); err != nil {
+		return nil, err
+	}
+
+	return place, nil
+}
+
+// findObjectByPath returns the object listed at path p.
+func (s *Service) findObjectByPath(account string, p string) (*orm.Object, error) {
+	place, err := s.resolvePlace(account, p)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &orm.Object{}
+	if err := s.visibleObjects(account, place.dirID).
+		Where("name = ?", place.name).
+		First(o).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errPathNotFound
+		}
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// visibleObjects selects the objects listed in a folder, leaving out
+// replicas, renewed objects and failed uploads.
+func (s *Service) visibleObjects(account string, dirID uint64) *gorm.DB {
+	return listedObjects(s.db, account, dirID)
+}
+
+// listedObjects is visibleObjects on the given db handle. The rows it
+// selects are the ones given a listed_path, whose unique key keeps two
+// objects from being listed at the same place.
+func listedObjects(db *gorm.DB, account string, dirID uint64) *gorm.DB {
+	return db.Model(&orm.Object{}).
+		Where("account = ? and directory_id = ?", account, dirID).
+		Where("replica_of = '' and renewed_by = '' and status != ?",
+			orm.ObjectFailed,
+		)
+}
+
+// checkPathFree fails with errPathExists if a folder or an object is
+// already listed at place. Within a transaction the reads lock the
+// place, so that no folder or object is added there until it ends.
+func checkPathFree(db *gorm.DB, place *objectPlace) error {
+	db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	count := int64(0)
+	if err := db.Model(&orm.Directory{}).
+		Where("account = ? and parent_id = ? and name = ?",
+			place.account,
+			place.dirID,
+			place.name,
+		).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if err := listedObjects(db, place.account, place.dirID).
+			Where("name = ?", place.name).
+			Count(&count).Error; err != nil {
+			return err
+		}
+	}
+
+	if count > 0 {
+		return errPathExists
+	}
+
+	return nil
+}
+
+// claimPlace runs write, which lists a folder or an object at place, in
+// a transaction checking again that place is free. A concurrent claim
+// of the same place getting through anyway is stopped by the unique
+// keys of the folders and the listed objects.
+func (s *Service) claimPlace(
+	place *objectPlace,
+	write func(tx *gorm.DB) error,
+) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkPathFree(tx, place); err != nil {
+			return err
+		}
+
+		return write(tx)
+	})
+	if isDuplicateKey(err) {
+		return errPathExists
+	}
+
+	return err
+}
+
+// isDuplicateKey reports whether err is a MySQL unique key violation.
+func isDuplicateKey(err error) bool {
+	var merr *mysql.MySQLError
+	return errors.As(err, &merr) && merr.Number == 1062
+}
+
+// lookupDir returns the id of the folder of the given path names, the
+// top level folder has id 0.
+func (s *Service) lookupDir(account string, names []string) (uint64, error) {
+	id := uint64(0)
+	for _, name := range names {
+		d := &orm.Directory{}
+		if err := s.db.Model(&orm.Directory{}).
+			Where("account = ? and parent_id = ? and name = ?",
+				account,
+				id,
+				name,
+			).
+			First(d).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return 0, errPathNotFound
+			}
+			return 0, err
+		}
+		id = d.ID
+	}
+
+	return id, nil
+}
+
+// makeDirs returns the id of the folder of the given path names,
+// creating the missing folders.
+func (s *Service) makeDirs(account string, names []string) (uint64, error) {
+	id := uint64(0)
+	for _, name := range names {
+		d := &orm.Directory{}
+		if err := s.db.Model(&orm.Directory{}).
+			Where("account = ? and parent_id = ? and name = ?",
+				account,
+				id,
+				name,
+			).
+			Attrs(&orm.Directory{
+				Account:  account,
+				ParentID: id,
+				Name:     name,
+			}).
+			FirstOrCreate(d).Error; err != nil {
+			return 0, err
+		}
+		id = d.ID
+	}
+
+	return id, nil
+}
+
+// splitPath returns the names of an absolute slash separated path.
+func splitPath(p string) ([]string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, errInvalidPath
+	}
+
+	p = path.Clean(p)
+	if p == "/" {
+		return nil, nil
+	}
+
+	names := strings.Split(p[1:], "/")
+	for _, name := range names {
+		if !validName(name) {
+			return nil, errInvalidPath
+		}
+	}
+
+	return names, nil
+}
+
+// validName reports whether name can be listed in a folder, a path
+// component which is neither empty, nor a dot name, nor too long.
+func validName(name string) bool {
+	return name != "" &&
+		name != "." &&
+		name != ".." &&
+		!strings.Contains(name, "/") &&
+		len(name) <= maxPathNameLength
+}
+
+func toDirectory(d *orm.Directory) *directory {
+	return &directory{
+		ID:        d.ID,
+		Name:      d.Name,
+		Timestamp: uint64(d.CreatedAt.Unix()),
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	long := strings.Repeat("a", maxPathNameLength+1)
+	cases := []struct {
+		name string
+		path string
+		want []string
+		err  error
+	}{
+		{name: "root", path: "/", want: nil},
+		{name: "file", path: "/a.txt", want: []string{"a.txt"}},
+		{name: "nested", path: "/a/b/c", want: []string{"a", "b", "c"}},
+		{name: "trailing slash", path: "/a/b/", want: []string{"a", "b"}},
+		{name: "double slash", path: "/a//b", want: []string{"a", "b"}},
+		{name: "dot", path: "/a/./b", want: []string{"a", "b"}},
+		{name: "dot dot", path: "/a/../b", want: []string{"b"}},
+		{name: "dot dot above root", path: "/../a", want: []string{"a"}},
+		{name: "relative", path: "a/b", err: errInvalidPath},
+		{name: "empty", path: "", err: errInvalidPath},
+		{name: "name too long", path: "/a/" + long, err: errInvalidPath},
+		{
+			name: "longest name",
+			path: "/" + long[1:],
+			want: []string{long[1:]},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := splitPath(tc.path)
+			if err != tc.err {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+
+			if strings.Join(got, "/") != strings.Join(tc.want, "/") ||
+				len(got) != len(tc.want) {
+				t.Fatalf("names = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidName(t *testing.T) {
+	long := strings.Repeat("a", maxPathNameLength+1)
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{name: "a.txt", want: true},
+		{name: ".hidden", want: true},
+		{name: "...", want: true},
+		{name: long[1:], want: true},
+		{name: "", want: false},
+		{name: ".", want: false},
+		{name: "..", want: false},
+		{name: "a/b", want: false},
+		{name: "/", want: false},
+		{name: long, want: false},
+	}
+
+	for _, tc := range cases {
+		if got := validName(tc.name); got != tc.want {
+			t.Fatalf("validName(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,95 +1,382 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/photon-storage/go-photon/crypto/codec"
-	"github.com/photon-storage/go-photon/crypto/sha256"
-	"github.com/photon-storage/go-photon/depot"
+	"github.com/photon-storage/go-common/log"
+
 	pbd "github.com/photon-storage/photon-proto/depot"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/database/orm"
 )
 
 const DownloadLabel = "download"
 
-// Download handles the /download request.
-func (s *Service) Download(c *gin.Context) error {
-	o := &orm.Object{}
-	if err := s.db.Model(&orm.Object{}).
-		Where("commit_tx_hash = ?", c.Query("hash")).
-		First(o).Error; err != nil {
+// Download handles the /download request. The object is addressed
+// either by its commit tx hash and ref id or by its path.
+func (s *Service) Download(c *gin.Context) (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveDownload(start, err)
+	}(time.Now())
+
+	o, err := s.downloadObject(c)
+	if err != nil {
 		return err
 	}
 
-	ohash, err := sha256.HashFromHex(o.Hash)
+	key, dk, err := s.objectKey(o, c.GetHeader(commitHeader("encryption_key")))
 	if err != nil {
 		return err
 	}
 
-	commitTxHash, err := sha256.HashFromHex(o.CommitTxHash)
+	// The headers are only sent with the first byte, so that the errors
+	// occurring until then are reported as JSON.
+	etag := fmt.Sprintf(`"%s"`, o.CommitTxHash)
+	header := http.Header{}
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", o.Name))
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", o.CreatedAt.UTC().Format(http.TimeFormat))
+	c.Set(DownloadLabel, nil)
+
+	ctx := c.Request.Context()
+	var w *bodyWriter
+	var write func(src *orm.Object, m *objectMeta) error
+	switch {
+	// Encrypted objects are decrypted as a whole, the segments can not
+	// be addressed by plain byte ranges.
+	case key != nil:
+		w = newBodyWriter(c, header, http.StatusOK, key.PlainSize)
+		write = func(src *orm.Object, m *objectMeta) error {
+			return s.writeDecrypted(ctx, w, m, src, dk)
+		}
+
+	// Encoded objects need every chunk to be decoded, they can neither
+	// be streamed nor served by byte ranges.
+	case !isPlainObject(o):
+		w = newBodyWriter(c, header, http.StatusOK, o.Size)
+		write = func(_ *orm.Object, m *objectMeta) error {
+			return s.writeEncoded(ctx, w, m)
+		}
+
+	default:
+		header.Set("Accept-Ranges", "bytes")
+		if o.Size == 0 {
+			newBodyWriter(c, header, http.StatusOK, 0).writeHeader()
+			return nil
+		}
+
+		// A Range whose If-Range precondition fails is ignored, the
+		// whole object is served even if the range is not satisfiable.
+		r := &byteRange{start: 0, end: o.Size - 1}
+		status := http.StatusOK
+		if ifRangeMatches(c, etag, o.CreatedAt) {
+			rr, err := parseRange(c.GetHeader("Range"), o.Size)
+			if err == errRangeNotSatisfiable {
+				header.Set("Content-Range", fmt.Sprintf("bytes */%d", o.Size))
+				newBodyWriter(
+					c,
+					header,
+					http.StatusRequestedRangeNotSatisfiable,
+					0,
+				).writeHeader()
+				return nil
+			}
+
+			if rr != nil {
+				r = rr
+				status = http.StatusPartialContent
+				header.Set("Content-Range", fmt.Sprintf(
+					"bytes %d-%d/%d",
+					r.start,
+					r.end,
+					o.Size,
+				))
+			}
+		}
+
+		w = newBodyWriter(c, header, status, r.length())
+		write = func(_ *orm.Object, m *objectMeta) error {
+			return s.writeWindow(ctx, w, m, r)
+		}
+	}
+
+	return s.writeFromCopies(ctx, w, o, write)
+}
+
+// writeFromCopies writes o with write, served by a readable copy of o.
+// The ETag and name stay those of the requested object when the content
+// is served by a replica. A copy failing before the first byte is sent
+// is skipped in favor of the next one.
+func (s *Service) writeFromCopies(
+	ctx context.Context,
+	w *bodyWriter,
+	o *orm.Object,
+	write func(src *orm.Object, m *objectMeta) error,
+) error {
+	tried := make([]uint64, 0)
+	writeErr := error(nil)
+	for {
+		src, m, err := s.readableCopy(ctx, o, tried...)
+		if err != nil {
+			// Report why the last copy failed rather than that no copy
+			// is left.
+			if writeErr != nil {
+				return writeErr
+			}
+
+			return err
+		}
+
+		writeErr = write(src, m)
+		if writeErr == nil || w.started || ctx.Err() != nil {
+			return writeErr
+		}
+
+		log.Error("download object copy failed, try next copy",
+			"commit_tx_hash", src.CommitTxHash,
+			"error", writeErr,
+		)
+		tried = append(tried, src.ID)
+	}
+}
+
+func (s *Service) downloadObject(c *gin.Context) (*orm.Object, error) {
+	if p := c.Query("path"); p != "" {
+		account, err := requireAccount(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.findObjectByPath(account, p)
+	}
+
+	o := &orm.Object{}
+	if err := s.ownedObjects(c).
+		Where(
+			"commit_tx_hash = ? and ref_id = ?",
+			c.Query("hash"),
+			c.Query("ref"),
+		).
+		First(o).Error; err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// writeObject writes the whole content of o.
+func (s *Service) writeObject(
+	ctx context.Context,
+	w io.Writer,
+	m *objectMeta,
+	o *orm.Object,
+) error {
+	if !isPlainObject(o) {
+		return s.writeEncoded(ctx, w, m)
+	}
+
+	if o.Size == 0 {
+		return nil
+	}
+
+	return s.writeWindow(ctx, w, m, &byteRange{start: 0, end: o.Size - 1})
+}
+
+// writeDecrypted writes the decrypted content of o.
+func (s *Service) writeDecrypted(
+	ctx context.Context,
+	w io.Writer,
+	m *objectMeta,
+	o *orm.Object,
+	dk []byte,
+) error {
+	dw, err := newDecryptWriter(w, dk)
 	if err != nil {
 		return err
 	}
 
-	objResp, err := s.depotCli.ObjectStatus(c, &pbd.ObjectStatusRequest{
-		Hash:         ohash.Bytes(),
-		CommitTxHash: commitTxHash.Bytes(),
-	})
-	if err != nil {
+	if err := s.writeObject(ctx, dw, m, o); err != nil {
 		return err
 	}
 
-	if objResp.Status != pbd.ObjectStatus_READABLE {
+	return dw.Close()
+}
+
+// bodyWriter sends the status and the headers of the response right
+// before the first write, so that errors occurring earlier are still
+// reported as JSON.
+type bodyWriter struct {
+	c       *gin.Context
+	header  http.Header
+	status  int
+	size    uint64
+	started bool
+}
+
+func newBodyWriter(
+	c *gin.Context,
+	header http.Header,
+	status int,
+	size uint64,
+) *bodyWriter {
+	return &bodyWriter{c: c, header: header, status: status, size: size}
+}
+
+func (w *bodyWriter) writeHeader() {
+	if w.started {
+		return
+	}
+
+	for k, vs := range w.header {
+		for _, v := range vs {
+			w.c.Writer.Header().Add(k, v)
+		}
+	}
+	w.c.Header("Content-Length", strconv.FormatUint(w.size, 10))
+	w.c.Status(w.status)
+	w.c.Writer.WriteHeaderNow()
+	w.started = true
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	w.writeHeader()
+	n, err := w.c.Writer.Write(p)
+	metrics.DownloadBytes.Add(float64(n))
+	return n, err
+}
+
+// writeWindow streams the bytes of r from a plain object, fetching only
+// the chunks covering the window and writing each one as soon as it is
+// verified.
+func (s *Service) writeWindow(
+	ctx context.Context,
+	w io.Writer,
+	m *objectMeta,
+	r *byteRange,
+) error {
+	// Objects stored before the chunk size was recorded learn it from
+	// their first chunk, which is then served from memory.
+	var head *pbd.Chunk
+	chunkSize := m.chunkSize
+	if chunkSize == 0 {
+		var err error
+		if head, err = s.fetchVerifiedChunk(ctx, m, 0); err != nil {
+			return err
+		}
+		chunkSize = uint64(len(chunkData(head)))
+	}
+
+	if chunkSize == 0 {
 		return errObjectNotReadable
 	}
 
-	decoder, err := codec.NewMultikey(objResp.Decoder)
-	if err != nil {
-		return err
+	first := r.start / chunkSize
+	last := r.end / chunkSize
+	if last >= uint64(m.status.NumChunks) {
+		return errObjectNotReadable
+	}
+
+	from := first
+	if head != nil && from == 0 {
+		from = 1
 	}
 
-	ehash, err := sha256.HashFromHex(o.EncodedHash)
+	var cs *chunkStream
+	if from <= last {
+		cs = s.streamChunks(
+			ctx,
+			m,
+			uint32(from),
+			uint32(last),
+			s.fetchVerifiedChunk,
+		)
+		defer cs.close()
+	}
+
+	for i := first; i <= last; i++ {
+		chunk := head
+		if i >= from {
+			var err error
+			if chunk, err = cs.next(); err != nil {
+				return err
+			}
+		}
+
+		offset := i * chunkSize
+		data := chunkData(chunk)
+		lo := uint64(0)
+		if r.start > offset {
+			lo = r.start - offset
+		}
+
+		// Every chunk but the last carries exactly chunkSize bytes.
+		if i != last && uint64(len(data)) != chunkSize {
+			return errObjectNotReadable
+		}
+
+		hi := uint64(len(data))
+		if r.end+1-offset < hi {
+			hi = r.end + 1 - offset
+		}
+
+		if lo > hi {
+			return errObjectNotReadable
+		}
+
+		if _, err := w.Write(data[lo:hi]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeEncoded decodes an encoded object and writes it. The chunks are
+// streamed in order and verified as they are set, but a download file
+// only decodes the object as a whole, so all of its chunks are held
+// until it is written.
+func (s *Service) writeEncoded(
+	ctx context.Context,
+	w io.Writer,
+	m *objectMeta,
+) error {
+	df, err := m.newDownloadFile()
 	if err != nil {
 		return err
 	}
 
-	df, err := depot.NewDownloadFile(
-		commitTxHash,
-		ohash,
-		ehash,
-		objResp.Size,
-		objResp.EncodedSize,
-		objResp.NumBlocks,
-		objResp.BlocksPerChunk,
-		objResp.NumChunks,
-		decoder,
+	if df.NumChunks() == 0 {
+		return errObjectNotReadable
+	}
+
+	// Chunks are verified by df itself when being set.
+	cs := s.streamChunks(
+		ctx,
+		m,
+		0,
+		df.NumChunks()-1,
+		s.downloadChunk,
 	)
+	defer cs.close()
 
 	for i := uint32(0); i < df.NumChunks(); i++ {
-		resp, err := s.depotCli.DownloadChunk(s.ctx, &pbd.DownloadChunkRequest{
-			Hash:         df.OriginalHash().Bytes(),
-			CommitTxHash: objResp.CommitTxHash,
-			Index:        i,
-		})
+		chunk, err := cs.next()
 		if err != nil {
 			return err
 		}
 
-		if err := df.SetChunk(i, resp.Chunk); err != nil {
+		if err := df.SetChunk(i, chunk); err != nil {
 			return err
 		}
 	}
 
-	if err := df.Write(c.Writer); err != nil {
-		return err
-	}
-
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", o.Name))
-	c.Header("Content-Length", strconv.Itoa(int(o.Size)))
-	c.Set(DownloadLabel, nil)
-	return nil
+	return df.Write(w)
 }
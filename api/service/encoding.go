@@ -0,0 +1,144 @@
+package service
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/photon-storage/go-photon/crypto/bls"
+	"github.com/photon-storage/go-photon/crypto/codec"
+	"github.com/photon-storage/go-photon/depot"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	encodingNone        = "none"
+	encodingReedSolomon = "reed_solomon"
+
+	// maxEncodingShards bounds the data and parity shards of an object
+	// together, the Reed-Solomon codec supports up to 256 shards.
+	maxEncodingShards = 256
+)
+
+// uploadEncoding defines how the content of an upload is erasure coded
+// and whether its blocks are signed by the owner key.
+type uploadEncoding struct {
+	Scheme       string
+	DataShards   uint32
+	ParityShards uint32
+	SignBlocks   bool
+}
+
+// uploadEncoding resolves the encoding of a request. Values are looked
+// up by their form name with get and fall back to the configured ones.
+func (s *Service) uploadEncoding(
+	get func(name string) string,
+) (*uploadEncoding, error) {
+	e := &uploadEncoding{
+		Scheme:       s.encodingCfg.Scheme,
+		DataShards:   s.encodingCfg.DataShards,
+		ParityShards: s.encodingCfg.ParityShards,
+		SignBlocks:   s.encodingCfg.SignBlocks,
+	}
+	if v := get("encoding"); v != "" {
+		e.Scheme = v
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"data_shards", &e.DataShards},
+		{"parity_shards", &e.ParityShards},
+	} {
+		v := get(f.name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, errInvalidEncoding
+		}
+		*f.value = uint32(n)
+	}
+
+	if v := get("sign_blocks"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errInvalidEncoding
+		}
+		e.SignBlocks = b
+	}
+
+	if err := validateEncoding(e.Scheme, e.DataShards, e.ParityShards); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// validateEncoding checks the scheme and, for Reed-Solomon, that there
+// are data and parity shards within maxEncodingShards.
+func validateEncoding(scheme string, data uint32, parity uint32) error {
+	switch scheme {
+	case encodingNone:
+		return nil
+	case encodingReedSolomon:
+	default:
+		return errInvalidEncoding
+	}
+
+	if data == 0 || parity == 0 ||
+		uint64(data)+uint64(parity) > maxEncodingShards {
+		return errInvalidEncoding
+	}
+
+	return nil
+}
+
+// newUploadFile prepares the content of r for the depot with encoding
+// e, sk signs the blocks if requested. depot.NewUploadFile holds the
+// chunks of the whole content in memory until the upload is done.
+func newUploadFile(
+	r io.Reader,
+	sk bls.SecretKey,
+	e *uploadEncoding,
+) (*depot.UploadFile, error) {
+	var signer depot.BlockSigner
+	if e.SignBlocks {
+		signer = depot.NewBlockSigner(sk)
+	}
+
+	var encoder codec.Encoder
+	if e.Scheme == encodingReedSolomon {
+		enc, err := codec.NewReedSolomon(
+			int(e.DataShards),
+			int(e.ParityShards),
+		)
+		if err != nil {
+			return nil, err
+		}
+		encoder = enc
+	}
+
+	return depot.NewUploadFile(r, signer, encoder)
+}
+
+func objectEncoding(o *orm.Object) *uploadEncoding {
+	return &uploadEncoding{
+		Scheme:       o.Encoding,
+		DataShards:   o.DataShards,
+		ParityShards: o.ParityShards,
+		SignBlocks:   o.SignBlocks,
+	}
+}
+
+func sessionEncoding(us *orm.UploadSession) *uploadEncoding {
+	return &uploadEncoding{
+		Scheme:       us.Encoding,
+		DataShards:   us.DataShards,
+		ParityShards: us.ParityShards,
+		SignBlocks:   us.SignBlocks,
+	}
+}
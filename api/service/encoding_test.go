@@ -0,0 +1,65 @@
+package service
+
+import "testing"
+
+func TestUploadEncoding(t *testing.T) {
+	cfg := EncodingConfig{Scheme: encodingReedSolomon}.withDefaults()
+	cases := []struct {
+		name   string
+		values map[string]string
+		want   *uploadEncoding
+		err    error
+	}{
+		{
+			name:   "configured",
+			values: map[string]string{},
+			want:   &uploadEncoding{Scheme: encodingReedSolomon, DataShards: 4, ParityShards: 2},
+		},
+		{
+			name:   "shards",
+			values: map[string]string{"data_shards": "10", "parity_shards": "4"},
+			want:   &uploadEncoding{Scheme: encodingReedSolomon, DataShards: 10, ParityShards: 4},
+		},
+		{
+			name:   "none",
+			values: map[string]string{"encoding": encodingNone},
+			want:   &uploadEncoding{Scheme: encodingNone, DataShards: 4, ParityShards: 2},
+		},
+		{
+			name:   "unknown scheme",
+			values: map[string]string{"encoding": "raid"},
+			err:    errInvalidEncoding,
+		},
+		{
+			name:   "no parity",
+			values: map[string]string{"parity_shards": "0"},
+			err:    errInvalidEncoding,
+		},
+		{
+			name:   "too many shards",
+			values: map[string]string{"data_shards": "250", "parity_shards": "7"},
+			err:    errInvalidEncoding,
+		},
+		{
+			name:   "malformed shards",
+			values: map[string]string{"data_shards": "-1"},
+			err:    errInvalidEncoding,
+		},
+	}
+
+	s := &Service{encodingCfg: cfg}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.uploadEncoding(func(name string) string {
+				return tc.values[name]
+			})
+			if err != tc.err {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+
+			if tc.want != nil && *got != *tc.want {
+				t.Fatalf("encoding = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
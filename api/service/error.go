@@ -5,9 +5,129 @@ import "github.com/pkg/errors"
 var (
 	errSystem            = errors.New("system error")
 	errObjectNotReadable = errors.New("object is not ready for reading")
+	errMissingFile       = errors.New("missing file in upload form")
+	errFormValueTooLarge = errors.New("upload form value is too large")
+	errMissingAccount    = errors.New("missing account")
+	errKeyNotFound       = errors.New("signing key of account not found")
+	errNoKeyAvailable    = errors.New("no unassigned signing key left")
+	errInvalidParam      = errors.New("invalid query parameter")
+
+	errSessionNotOpen        = errors.New("upload session is not open")
+	errSessionOffsetMismatch = errors.New("upload session offset mismatch")
+	errSessionSizeExceeded   = errors.New("upload session size exceeded")
+	errSessionIncomplete     = errors.New("upload session is incomplete")
+	errSessionStateChanged   = errors.New("upload session state has changed")
+
+	errSessionNotAwaitingSignature = errors.New("upload session is not awaiting signature")
+	errInvalidPublicKey            = errors.New("invalid owner public key")
+	errInvalidSignature            = errors.New("invalid commit tx signature")
+	errSessionOtherNode            = errors.New("upload session belongs to another node")
+
+	errInvalidCommitParam    = errors.New("invalid commit parameter")
+	errCommitParamOutOfRange = errors.New("commit parameter out of range")
+	errInvalidRetention      = errors.New("invalid retention policy")
+	errRetentionNotSupported = errors.New("retention policy not supported for object")
+
+	errNoDepotAvailable = errors.New("no depot available")
+	errDepotUnavailable = errors.New("depot of object unavailable")
+
+	errReplicationNotSupported = errors.New("replication not supported for client signed uploads")
+
+	errInvalidEncoding        = errors.New("invalid upload encoding")
+	errSignBlocksNotSupported = errors.New("block signing not supported for client signed uploads")
+
+	errInvalidEncryption       = errors.New("invalid upload encryption")
+	errEncryptionNotConfigured = errors.New("encryption master key not configured")
+	errInvalidEncryptionKey    = errors.New("invalid encryption key")
+	errEncryptionKeyRequired   = errors.New("encryption key required")
+	errUserKeyNotSupported     = errors.New("user encryption key not supported for upload sessions")
+
+	errInvalidDedup = errors.New("invalid dedup value")
+
+	errInvalidPath  = errors.New("invalid path")
+	errPathNotFound = errors.New("path not found")
+	errPathExists   = errors.New("path already exists")
+	errMoveIntoSelf = errors.New("directory can not be moved into itself")
+
+	errUnauthenticated = errors.New("unauthenticated")
+	errInvalidAccount  = errors.New("invalid account name")
+	errInvalidPassword = errors.New("invalid password")
+	errAccountExists   = errors.New("account already exists")
+	errForbidden       = errors.New("scope not granted")
+	errInvalidScope    = errors.New("invalid api key scope")
+	errAPIKeyNotFound  = errors.New("api key not found")
+
+	errInvalidChallenge = errors.New("invalid or expired login challenge")
+	errAccountRequired  = errors.New("request requires an account")
+
+	errFileTooLarge         = errors.New("file too large")
+	errStorageQuotaExceeded = errors.New("storage quota exceeded")
+	errObjectQuotaExceeded  = errors.New("object quota exceeded")
+
+	ErrRateLimited = errors.New("rate limit exceeded")
 )
 
 var ErrorCode = map[error]int{
 	errSystem:            1000,
 	errObjectNotReadable: 1001,
+	errMissingFile:       1002,
+	errFormValueTooLarge: 1003,
+	errMissingAccount:    1004,
+	errKeyNotFound:       1005,
+	errNoKeyAvailable:    1006,
+	errInvalidParam:      1007,
+
+	errSessionNotOpen:        1100,
+	errSessionOffsetMismatch: 1101,
+	errSessionSizeExceeded:   1102,
+	errSessionIncomplete:     1103,
+	errSessionStateChanged:   1104,
+
+	errSessionNotAwaitingSignature: 1105,
+	errInvalidPublicKey:            1106,
+	errInvalidSignature:            1107,
+	errSessionOtherNode:            1108,
+
+	errInvalidCommitParam:    1200,
+	errCommitParamOutOfRange: 1201,
+	errInvalidRetention:      1202,
+	errRetentionNotSupported: 1203,
+
+	errNoDepotAvailable: 1300,
+	errDepotUnavailable: 1301,
+
+	errReplicationNotSupported: 1302,
+
+	errInvalidEncoding:        1400,
+	errSignBlocksNotSupported: 1401,
+
+	errInvalidEncryption:       1500,
+	errEncryptionNotConfigured: 1501,
+	errInvalidEncryptionKey:    1502,
+	errEncryptionKeyRequired:   1503,
+	errUserKeyNotSupported:     1504,
+
+	errInvalidDedup: 1600,
+
+	errInvalidPath:  1700,
+	errPathNotFound: 1701,
+	errPathExists:   1702,
+	errMoveIntoSelf: 1703,
+
+	errUnauthenticated: 1800,
+	errInvalidAccount:  1801,
+	errInvalidPassword: 1802,
+	errAccountExists:   1803,
+	errForbidden:       1804,
+	errInvalidScope:    1805,
+	errAPIKeyNotFound:  1806,
+
+	errInvalidChallenge: 1807,
+	errAccountRequired:  1808,
+
+	errFileTooLarge:         1900,
+	errStorageQuotaExceeded: 1901,
+	errObjectQuotaExceeded:  1902,
+
+	ErrRateLimited: 2000,
 }
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"io"
+	"mime/multipart"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	fileFormName     = "file"
+	maxFormValueSize = 1 << 10
+)
+
+// uploadForm is a streaming view over a multipart upload request.
+// Plain form values must precede the file part, which is left unread
+// so that it can be consumed incrementally by the caller.
+type uploadForm struct {
+	values url.Values
+	file   *multipart.Part
+}
+
+// openUploadForm reads the multipart body up to the file part without
+// spooling the file content to memory or temporary disk.
+func openUploadForm(c *gin.Context) (*uploadForm, error) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if p.FormName() == fileFormName {
+			return &uploadForm{
+				values: values,
+				file:   p,
+			}, nil
+		}
+
+		v, err := io.ReadAll(io.LimitReader(p, maxFormValueSize+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(v) > maxFormValueSize {
+			return nil, errFormValueTooLarge
+		}
+
+		values.Add(p.FormName(), string(v))
+	}
+}
+
+func (f *uploadForm) fileName() string {
+	return f.file.FileName()
+}
+
+func (f *uploadForm) Close() error {
+	return f.file.Close()
+}
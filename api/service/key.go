@@ -5,23 +5,48 @@ import (
 
 	"github.com/photon-storage/go-photon/crypto/bls"
 	"github.com/photon-storage/go-photon/crypto/interop"
-	"github.com/photon-storage/go-photon/sak/guard"
 )
 
 const keySize = 10
 
-var (
+// KeyProvider provides the keys used to sign commit transactions on
+// behalf of accounts.
+type KeyProvider interface {
+	// Key returns the signing key assigned to the account.
+	Key(account string) (bls.SecretKey, error)
+	// KeyOf returns the held key of the hex encoded public key, it
+	// fails with errKeyNotFound if the key is not held.
+	KeyOf(pk string) (bls.SecretKey, error)
+}
+
+// interopKeys hands out the deterministic interop keys in round robin
+// regardless of the account. It is only meant for devnet demos since
+// anyone can derive the keys.
+type interopKeys struct {
 	next uint32
 	sks  []bls.SecretKey
-)
+}
 
-func init() {
-	var err error
-	sks, _, err = interop.DeterministicallyGenerateKeys(0, keySize)
-	guard.NoError(err)
+func newInteropKeys() (*interopKeys, error) {
+	sks, _, err := interop.DeterministicallyGenerateKeys(0, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &interopKeys{sks: sks}, nil
+}
+
+func (k *interopKeys) Key(_ string) (bls.SecretKey, error) {
+	n := atomic.AddUint32(&k.next, 1)
+	return k.sks[(n-1)%uint32(len(k.sks))], nil
 }
 
-func nextSk() bls.SecretKey {
-	n := atomic.AddUint32(&next, 1)
-	return sks[(n-1)%keySize]
+func (k *interopKeys) KeyOf(pk string) (bls.SecretKey, error) {
+	for _, sk := range k.sks {
+		if sk.PublicKey().Hex() == pk {
+			return sk, nil
+		}
+	}
+
+	return nil, errKeyNotFound
 }
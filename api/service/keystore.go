@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-photon/crypto/bls"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// keystoreFile is the EIP-2335 BLS keystore format.
+type keystoreFile struct {
+	Crypto  map[string]any `json:"crypto"`
+	Pubkey  string         `json:"pubkey"`
+	Version uint           `json:"version"`
+}
+
+// keystoreKeys serves keys decrypted from a directory of EIP-2335
+// keystore files. Every account is bound to a key of its own on first
+// use, the binding is persisted so that the account keeps owning its
+// objects. The directory needs a key for every account.
+type keystoreKeys struct {
+	db  *gorm.DB
+	sks map[string]bls.SecretKey
+	pks []string
+}
+
+func newKeystoreKeys(
+	db *gorm.DB,
+	dir string,
+	passwordFile string,
+) (*keystoreKeys, error) {
+	password, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read keystore password failed")
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	k := &keystoreKeys{
+		db:  db,
+		sks: make(map[string]bls.SecretKey),
+	}
+	encryptor := keystorev4.New()
+	for _, path := range paths {
+		sk, err := decryptKeystore(
+			encryptor,
+			path,
+			strings.TrimSpace(string(password)),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "keystore: %s", path)
+		}
+
+		pk := sk.PublicKey().Hex()
+		k.sks[pk] = sk
+		k.pks = append(k.pks, pk)
+	}
+
+	if len(k.pks) == 0 {
+		return nil, errors.Errorf("no keystore found in %s", dir)
+	}
+	sort.Strings(k.pks)
+
+	return k, nil
+}
+
+func decryptKeystore(
+	encryptor *keystorev4.Encryptor,
+	path string,
+	password string,
+) (bls.SecretKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &keystoreFile{}
+	if err := json.Unmarshal(raw, ks); err != nil {
+		return nil, err
+	}
+
+	secret, err := encryptor.Decrypt(ks.Crypto, password)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := bls.SecretKeyFromBytes(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := hex.DecodeString(strings.TrimPrefix(ks.Pubkey, "0x"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(pk, sk.PublicKey().Bytes()) {
+		return nil, errors.New("keystore public key mismatch")
+	}
+
+	return sk, nil
+}
+
+func (k *keystoreKeys) Key(account string) (bls.SecretKey, error) {
+	if account == "" {
+		return nil, errMissingAccount
+	}
+
+	ak, err := k.assignment(account)
+	if err == gorm.ErrRecordNotFound {
+		ak, err = k.assign(account)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sk, ok := k.sks[ak.PublicKey]
+	if !ok {
+		return nil, errors.Wrapf(errKeyNotFound, "account: %s", account)
+	}
+
+	return sk, nil
+}
+
+func (k *keystoreKeys) KeyOf(pk string) (bls.SecretKey, error) {
+	sk, ok := k.sks[pk]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+
+	return sk, nil
+}
+
+func (k *keystoreKeys) assignment(account string) (*orm.AccountKey, error) {
+	ak := &orm.AccountKey{}
+	if err := k.db.Model(&orm.AccountKey{}).
+		Where("account = ?", account).
+		First(ak).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return ak, nil
+}
+
+// assign binds the account to a key no other account is bound to. The
+// unique public key index settles concurrent assignments of the same
+// key, the loser moves on to the next free key.
+func (k *keystoreKeys) assign(account string) (*orm.AccountKey, error) {
+	for {
+		used := make([]string, 0)
+		if err := k.db.Model(&orm.AccountKey{}).
+			Pluck("public_key", &used).
+			Error; err != nil {
+			return nil, err
+		}
+
+		pick := freeKey(k.pks, used)
+		if pick == "" {
+			return nil, errors.Wrapf(
+				errNoKeyAvailable,
+				"keys: %d",
+				len(k.pks),
+			)
+		}
+
+		ak := &orm.AccountKey{
+			Account:   account,
+			PublicKey: pick,
+		}
+		err := k.db.Model(&orm.AccountKey{}).Create(ak).Error
+		if err == nil {
+			return ak, nil
+		}
+
+		// The account may have been assigned concurrently.
+		if existing, e := k.assignment(account); e == nil {
+			return existing, nil
+		}
+
+		// Otherwise the key was taken, unless it was another error.
+		taken := int64(0)
+		if e := k.db.Model(&orm.AccountKey{}).
+			Where("public_key = ?", pick).
+			Count(&taken).
+			Error; e != nil || taken == 0 {
+			return nil, err
+		}
+	}
+}
+
+// freeKey returns the first of pks not in used, or "" if all are used.
+func freeKey(pks []string, used []string) string {
+	taken := make(map[string]bool, len(used))
+	for _, pk := range used {
+		taken[pk] = true
+	}
+
+	for _, pk := range pks {
+		if !taken[pk] {
+			return pk
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+
+	pbd "github.com/photon-storage/photon-proto/depot"
+
+	"github.com/photo-storage/dropbox/api/metrics"
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// meteredDepotClient records the latency and the errors of the depot
+// RPCs.
+type meteredDepotClient struct {
+	pbd.DepotClient
+}
+
+func (m meteredDepotClient) State(
+	ctx context.Context,
+	in *emptypb.Empty,
+	opts ...grpc.CallOption,
+) (*pbd.StateResponse, error) {
+	start := time.Now()
+	resp, err := m.DepotClient.State(ctx, in, opts...)
+	metrics.ObserveDepotRPC("State", start, err)
+	return resp, err
+}
+
+func (m meteredDepotClient) ObjectStatus(
+	ctx context.Context,
+	in *pbd.ObjectStatusRequest,
+	opts ...grpc.CallOption,
+) (*pbd.ObjectStatusResponse, error) {
+	start := time.Now()
+	resp, err := m.DepotClient.ObjectStatus(ctx, in, opts...)
+	metrics.ObserveDepotRPC("ObjectStatus", start, err)
+	return resp, err
+}
+
+func (m meteredDepotClient) UploadInit(
+	ctx context.Context,
+	in *pbd.UploadInitRequest,
+	opts ...grpc.CallOption,
+) (*pbd.UploadInitResponse, error) {
+	start := time.Now()
+	resp, err := m.DepotClient.UploadInit(ctx, in, opts...)
+	metrics.ObserveDepotRPC("UploadInit", start, err)
+	return resp, err
+}
+
+func (m meteredDepotClient) UploadChunk(
+	ctx context.Context,
+	in *pbd.UploadChunkRequest,
+	opts ...grpc.CallOption,
+) (*pbd.UploadChunkResponse, error) {
+	start := time.Now()
+	resp, err := m.DepotClient.UploadChunk(ctx, in, opts...)
+	metrics.ObserveDepotRPC("UploadChunk", start, err)
+	return resp, err
+}
+
+func (m meteredDepotClient) DownloadChunk(
+	ctx context.Context,
+	in *pbd.DownloadChunkRequest,
+	opts ...grpc.CallOption,
+) (*pbd.DownloadChunkResponse, error) {
+	start := time.Now()
+	resp, err := m.DepotClient.DownloadChunk(ctx, in, opts...)
+	metrics.ObserveDepotRPC("DownloadChunk", start, err)
+	return resp, err
+}
+
+// countObjects updates the number of objects by status.
+func countObjects(db *gorm.DB) error {
+	counts := make([]struct {
+		Status orm.ObjectStatus
+		Count  int64
+	}, 0)
+	if err := db.Model(&orm.Object{}).
+		Select("status, count(*) as count").
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	metrics.Objects.Reset()
+	for _, c := range counts {
+		metrics.Objects.WithLabelValues(c.Status.String()).Set(float64(c.Count))
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	pbc "github.com/photon-storage/photon-proto/consensus"
+)
+
+// pendingTimeout is the period after which a transaction that has
+// neither been confirmed nor failed is considered dropped.
+const pendingTimeout = time.Hour
+
+// nonceManager hands out the nonces of the service signing keys. The
+// nonces of pending transactions are tracked locally so that concurrent
+// uploads signed by the same key get monotonically increasing nonces.
+// The nonce of a transaction that was never submitted is handed out
+// again. The next nonce is resynced from the node whenever there is
+// nothing pending or a submitted transaction gets dropped.
+type nonceManager struct {
+	nodeCli  pbc.NodeClient
+	mu       sync.Mutex
+	accounts map[string]*nonceState
+}
+
+type nonceState struct {
+	mu      sync.Mutex
+	synced  bool
+	next    uint64
+	pending map[uint64]time.Time
+	// released holds the nonces below next given back by transactions
+	// never submitted, they are handed out before next.
+	released map[uint64]bool
+}
+
+func newNonceManager(nodeCli pbc.NodeClient) *nonceManager {
+	return &nonceManager{
+		nodeCli:  nodeCli,
+		accounts: make(map[string]*nonceState),
+	}
+}
+
+func (m *nonceManager) state(pk []byte) *nonceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := hex.EncodeToString(pk)
+	st, ok := m.accounts[key]
+	if !ok {
+		st = &nonceState{}
+		st.reset()
+		m.accounts[key] = st
+	}
+
+	return st
+}
+
+// next returns the nonce to be used by the next transaction of pk.
+func (m *nonceManager) next(ctx context.Context, pk []byte) (uint64, error) {
+	st := m.state(pk)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, t := range st.pending {
+		if time.Since(t) > pendingTimeout {
+			st.reset()
+			break
+		}
+	}
+
+	if !st.synced || len(st.pending) == 0 {
+		acct, err := m.nodeCli.GetAccount(
+			ctx,
+			&pbc.AccountRequest{Address: pk},
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		st.next = acct.Nonce
+		st.released = make(map[uint64]bool)
+		st.synced = true
+	}
+
+	n := st.next
+	for r := range st.released {
+		if r < n {
+			n = r
+		}
+	}
+
+	if n == st.next {
+		st.next++
+	} else {
+		delete(st.released, n)
+	}
+	st.pending[n] = time.Now()
+	return n, nil
+}
+
+// confirm marks the transaction of pk with the given nonce as included
+// in the chain.
+func (m *nonceManager) confirm(pk []byte, nonce uint64) {
+	st := m.state(pk)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.pending, nonce)
+}
+
+// release gives back the nonce of a transaction of pk that was never
+// submitted, so that the next transaction fills the gap.
+func (m *nonceManager) release(pk []byte, nonce uint64) {
+	st := m.state(pk)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.pending[nonce]; !ok {
+		return
+	}
+
+	delete(st.pending, nonce)
+	st.released[nonce] = true
+	for st.next > 0 && st.released[st.next-1] {
+		st.next--
+		delete(st.released, st.next)
+	}
+}
+
+// fail marks a submitted transaction of pk as failed or dropped. The
+// transactions pending after it can not be included either, so the
+// nonce is resynced from the node on the next use.
+func (m *nonceManager) fail(pk []byte) {
+	st := m.state(pk)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.reset()
+}
+
+func (st *nonceState) reset() {
+	st.synced = false
+	st.pending = make(map[uint64]time.Time)
+	st.released = make(map[uint64]bool)
+}
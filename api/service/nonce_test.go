@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pbc "github.com/photon-storage/photon-proto/consensus"
+)
+
+type fakeNodeClient struct {
+	pbc.NodeClient
+	nonce uint64
+	calls int
+}
+
+func (f *fakeNodeClient) GetAccount(
+	_ context.Context,
+	_ *pbc.AccountRequest,
+	_ ...grpc.CallOption,
+) (*pbc.Account, error) {
+	f.calls++
+	return &pbc.Account{Nonce: f.nonce}, nil
+}
+
+func TestNonceManager(t *testing.T) {
+	type op struct {
+		// action is one of next, confirm, release and fail.
+		action string
+		nonce  uint64
+	}
+
+	cases := []struct {
+		name  string
+		ops   []op
+		want  []uint64
+		syncs int
+	}{
+		{
+			name:  "increasing",
+			ops:   []op{{action: "next"}, {action: "next"}, {action: "next"}},
+			want:  []uint64{5, 6, 7},
+			syncs: 1,
+		},
+		{
+			name: "confirmed keeps counting",
+			ops: []op{
+				{action: "next"},
+				{action: "next"},
+				{action: "confirm", nonce: 5},
+				{action: "next"},
+			},
+			want:  []uint64{5, 6, 7},
+			syncs: 1,
+		},
+		{
+			name: "nothing pending resyncs",
+			ops: []op{
+				{action: "next"},
+				{action: "confirm", nonce: 5},
+				{action: "next"},
+			},
+			want:  []uint64{5, 5},
+			syncs: 2,
+		},
+		{
+			name: "release latest",
+			ops: []op{
+				{action: "next"},
+				{action: "next"},
+				{action: "release", nonce: 6},
+				{action: "next"},
+				{action: "next"},
+			},
+			want:  []uint64{5, 6, 6, 7},
+			syncs: 1,
+		},
+		{
+			name: "release fills gap first",
+			ops: []op{
+				{action: "next"},
+				{action: "next"},
+				{action: "next"},
+				{action: "release", nonce: 6},
+				{action: "next"},
+				{action: "next"},
+			},
+			want:  []uint64{5, 6, 7, 6, 8},
+			syncs: 1,
+		},
+		{
+			name: "release unknown nonce",
+			ops: []op{
+				{action: "next"},
+				{action: "next"},
+				{action: "release", nonce: 9},
+				{action: "next"},
+			},
+			want:  []uint64{5, 6, 7},
+			syncs: 1,
+		},
+		{
+			name: "fail resyncs",
+			ops: []op{
+				{action: "next"},
+				{action: "next"},
+				{action: "fail"},
+				{action: "next"},
+			},
+			want:  []uint64{5, 6, 5},
+			syncs: 2,
+		},
+	}
+
+	pk := []byte{1}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := &fakeNodeClient{nonce: 5}
+			m := newNonceManager(cli)
+			got := make([]uint64, 0)
+			for _, o := range tc.ops {
+				switch o.action {
+				case "next":
+					n, err := m.next(context.Background(), pk)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, n)
+				case "confirm":
+					m.confirm(pk, o.nonce)
+				case "release":
+					m.release(pk, o.nonce)
+				case "fail":
+					m.fail(pk)
+				}
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("nonces = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("nonces = %v, want %v", got, tc.want)
+				}
+			}
+
+			if cli.calls != tc.syncs {
+				t.Fatalf("syncs = %d, want %d", cli.calls, tc.syncs)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/docker/go-units"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	"github.com/photo-storage/dropbox/api/pagination"
 	"github.com/photo-storage/dropbox/database/orm"
@@ -11,19 +15,54 @@ import (
 type object struct {
 	FileName     string `json:"file_name"`
 	CommitTxHash string `json:"commit_tx_hash"`
+	RefID        string `json:"ref_id,omitempty"`
+	DirectoryID  uint64 `json:"directory_id"`
 	CID          string `json:"cid"`
 	Status       string `json:"status"`
 	Timestamp    uint64 `json:"timestamp"`
 	Size         string `json:"size"`
+	Duration     uint64 `json:"duration"`
+	Fee          uint64 `json:"fee"`
+	Pledge       uint64 `json:"pledge"`
+	GasPrice     uint64 `json:"gas_price"`
+	CommitSlot   uint64 `json:"commit_slot"`
+	ExpirySlot   uint64 `json:"expiry_slot"`
+	ExpiresAt    uint64 `json:"expires_at,omitempty"`
+	Retention    string `json:"retention"`
+	RenewalOf    string `json:"renewal_of,omitempty"`
+	RenewedBy    string `json:"renewed_by,omitempty"`
+	Replicas     uint64 `json:"replicas"`
+	Encoding     string `json:"encoding"`
+	SignBlocks   bool   `json:"sign_blocks"`
 }
 
-// Objects handles the /objects request.
+// Objects handles the /objects request. Objects can be limited to the
+// ones expiring within the given number of days by expiring_within.
 func (s *Service) Objects(
-	_ *gin.Context,
+	c *gin.Context,
 	page *pagination.Query,
 ) (*pagination.Result, error) {
+	// Replicas are listed through their primary object, a renewed
+	// object through its renewal.
+	query := s.ownedObjects(c).Where("replica_of = '' and renewed_by = ''")
+	if v := c.Query("expiring_within"); v != "" {
+		days, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, errInvalidParam
+		}
+
+		query = query.Where(
+			"status in (?,?) and expires_at <= ?",
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+			time.Now().Add(time.Duration(days)*24*time.Hour),
+		)
+	}
+
+	// Share the conditions between the page and the count queries.
+	query = query.Session(&gorm.Session{})
 	objects := make([]*orm.Object, 0)
-	if err := s.db.Model(&orm.Object{}).
+	if err := query.
 		Offset(page.Start).
 		Limit(page.Limit).
 		Order("id desc").
@@ -34,18 +73,11 @@ func (s *Service) Objects(
 
 	os := make([]*object, len(objects))
 	for i, o := range objects {
-		os[i] = &object{
-			FileName:     o.Name,
-			CommitTxHash: o.CommitTxHash,
-			CID:          o.Cid,
-			Status:       o.Status.String(),
-			Timestamp:    uint64(o.CreatedAt.Unix()),
-			Size:         units.HumanSize(float64(o.Size)),
-		}
+		os[i] = toObject(o)
 	}
 
 	count := int64(0)
-	if err := s.db.Model(&orm.Object{}).Count(&count).Error; err != nil {
+	if err := query.Count(&count).Error; err != nil {
 		return nil, err
 	}
 
@@ -54,3 +86,37 @@ func (s *Service) Objects(
 		Total: count,
 	}, nil
 }
+
+func toObject(o *orm.Object) *object {
+	return &object{
+		FileName:     o.Name,
+		CommitTxHash: o.CommitTxHash,
+		DirectoryID:  o.DirectoryID,
+		RefID:        o.RefID,
+		CID:          o.Cid,
+		Status:       o.Status.String(),
+		Timestamp:    uint64(o.CreatedAt.Unix()),
+		Size:         units.HumanSize(float64(o.Size)),
+		Duration:     o.Duration,
+		Fee:          o.Fee,
+		Pledge:       o.Pledge,
+		GasPrice:     o.GasPrice,
+		CommitSlot:   o.CommitSlot,
+		ExpirySlot:   o.ExpirySlot,
+		Retention:    o.Retention.String(),
+		ExpiresAt:    unixOf(o.ExpiresAt),
+		RenewalOf:    o.RenewalOf,
+		RenewedBy:    o.RenewedBy,
+		Replicas:     o.Replicas,
+		Encoding:     o.Encoding,
+		SignBlocks:   o.SignBlocks,
+	}
+}
+
+func unixOf(t *time.Time) uint64 {
+	if t == nil {
+		return 0
+	}
+
+	return uint64(t.Unix())
+}
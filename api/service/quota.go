@@ -0,0 +1,209 @@
+package service
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// maxFormOverhead is the room left to the form values and the multipart
+// framing when checking the length of an upload request.
+const maxFormOverhead = 64 << 10
+
+// usageColumns sums the bytes of every copy of the rows, an object has
+// at least one copy.
+const usageColumns = "coalesce(sum(size * greatest(replicas, 1)), 0) as bytes, " +
+	"count(*) as objects"
+
+type quotaResp struct {
+	UsedBytes   uint64 `json:"used_bytes"`
+	MaxBytes    uint64 `json:"max_bytes,omitempty"`
+	Objects     uint64 `json:"objects"`
+	MaxObjects  uint64 `json:"max_objects,omitempty"`
+	MaxFileSize uint64 `json:"max_file_size,omitempty"`
+}
+
+type usage struct {
+	Bytes   uint64
+	Objects uint64
+}
+
+// Quota handles the /quota request reporting the storage used by the
+// account against its limits, a zero limit is unlimited. The usage
+// includes the space reserved by upload sessions in progress.
+func (s *Service) Quota(c *gin.Context) (*quotaResp, error) {
+	account, err := requireAccount(c)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := accountUsage(s.db, account, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quotaResp{
+		UsedBytes:   u.Bytes,
+		MaxBytes:    s.quotaCfg.MaxBytes,
+		Objects:     u.Objects,
+		MaxObjects:  s.quotaCfg.MaxObjects,
+		MaxFileSize: s.quotaCfg.MaxFileSize,
+	}, nil
+}
+
+// accountUsage sums the objects listed in the folders of the account,
+// the content shared by deduplicated objects is counted for each. The
+// bytes of an object are counted once per copy the service pays for,
+// the replicas included. The upload sessions in progress reserve their
+// size and an object the same way until their object is inserted,
+// except the session of the given id.
+func accountUsage(
+	db *gorm.DB,
+	account string,
+	exceptSession uint64,
+) (*usage, error) {
+	u := &usage{}
+	if err := db.Model(&orm.Object{}).
+		Select(usageColumns).
+		Where("account = ? and replica_of = '' and renewed_by = ''", account).
+		Where("status not in (?,?)", orm.ObjectFailed, orm.ObjectExpired).
+		Scan(u).Error; err != nil {
+		return nil, err
+	}
+
+	inserted := db.Model(&orm.Object{}).
+		Select("1").
+		Where("objects.commit_tx_hash = upload_sessions.commit_tx_hash and objects.ref_id = ''")
+	reserved := &usage{}
+	if err := db.Model(&orm.UploadSession{}).
+		Select(usageColumns).
+		Where("account = ? and id != ?", account, exceptSession).
+		Where("status in (?,?,?,?)",
+			orm.SessionOpen,
+			orm.SessionReceiving,
+			orm.SessionAwaitingSignature,
+			orm.SessionCommitting,
+		).
+		Where("(commit_tx_hash = '' or not exists (?))", inserted).
+		Scan(reserved).Error; err != nil {
+		return nil, err
+	}
+
+	u.Bytes += reserved.Bytes
+	u.Objects += reserved.Objects
+	return u, nil
+}
+
+// checkQuota fails if a file of the given size is too large or does not
+// fit in the quota of the account with all its replicas, leaving out
+// the reservation of the upload session of the given id. Within a
+// transaction the account is locked, so that concurrent checks of the
+// account do not both pass.
+func (s *Service) checkQuota(
+	db *gorm.DB,
+	account string,
+	size uint64,
+	replicas uint64,
+	exceptSession uint64,
+) error {
+	if s.quotaCfg.MaxFileSize > 0 && size > s.quotaCfg.MaxFileSize {
+		return errFileTooLarge
+	}
+
+	if s.quotaCfg.MaxBytes == 0 && s.quotaCfg.MaxObjects == 0 {
+		return nil
+	}
+
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Model(&orm.Account{}).
+		Where("name = ?", account).
+		Find(&orm.Account{}).Error; err != nil {
+		return err
+	}
+
+	u, err := accountUsage(db, account, exceptSession)
+	if err != nil {
+		return err
+	}
+
+	if s.quotaCfg.MaxObjects > 0 && u.Objects >= s.quotaCfg.MaxObjects {
+		return errObjectQuotaExceeded
+	}
+
+	if s.quotaCfg.MaxBytes > 0 &&
+		u.Bytes+storedBytes(size, replicas) > s.quotaCfg.MaxBytes {
+		return errStorageQuotaExceeded
+	}
+
+	return nil
+}
+
+// storedBytes returns the bytes stored for a file of the given size
+// with all its replicas.
+func storedBytes(size uint64, replicas uint64) uint64 {
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	return size * replicas
+}
+
+// checkUploadLength rejects an upload request before reading its body
+// when its length or the length of its file part, if given, is beyond
+// the maximum file size.
+func (s *Service) checkUploadLength(c *gin.Context) error {
+	max := s.quotaCfg.MaxFileSize
+	if max == 0 {
+		return nil
+	}
+
+	if c.Request.ContentLength > int64(max+maxFormOverhead) {
+		return errFileTooLarge
+	}
+
+	return nil
+}
+
+func (s *Service) checkPartLength(f *uploadForm) error {
+	max := s.quotaCfg.MaxFileSize
+	v := f.file.Header.Get("Content-Length")
+	if max == 0 || v == "" {
+		return nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	if n > max {
+		return errFileTooLarge
+	}
+
+	return nil
+}
+
+// limitedReader reads up to max bytes from r, it fails with
+// errFileTooLarge past that. A zero max is unlimited.
+type limitedReader struct {
+	r        io.Reader
+	max      uint64
+	n        uint64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += uint64(n)
+	if l.max > 0 && l.n > l.max {
+		l.exceeded = true
+		return n, errFileTooLarge
+	}
+
+	return n, err
+}
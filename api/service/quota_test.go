@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// quotaStore is an in-memory stand-in of the tables read by the quota.
+// A locking read holds the row lock of every account until the end of
+// the transaction, like a locking read of an InnoDB row would for the
+// transactions of one account.
+type quotaStore struct {
+	lock     sync.Mutex
+	mu       sync.Mutex
+	reserved int64
+	sessions int64
+	nextID   int64
+}
+
+func (st *quotaStore) Connect(context.Context) (driver.Conn, error) {
+	return &quotaConn{st: st}, nil
+}
+
+func (st *quotaStore) Driver() driver.Driver {
+	return nil
+}
+
+type quotaConn struct {
+	st     *quotaStore
+	locked bool
+}
+
+func (c *quotaConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *quotaConn) Close() error {
+	return nil
+}
+
+func (c *quotaConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *quotaConn) BeginTx(
+	context.Context,
+	driver.TxOptions,
+) (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *quotaConn) Commit() error {
+	c.unlock()
+	return nil
+}
+
+func (c *quotaConn) Rollback() error {
+	c.unlock()
+	return nil
+}
+
+func (c *quotaConn) unlock() {
+	if c.locked {
+		c.locked = false
+		c.st.lock.Unlock()
+	}
+}
+
+func (c *quotaConn) QueryContext(
+	_ context.Context,
+	query string,
+	_ []driver.NamedValue,
+) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "FROM `accounts`"):
+		if strings.Contains(query, "FOR UPDATE") && !c.locked {
+			c.st.lock.Lock()
+			c.locked = true
+		}
+		return &quotaRows{cols: []string{"id"}}, nil
+
+	case strings.Contains(query, "FROM `upload_sessions`"):
+		c.st.mu.Lock()
+		defer c.st.mu.Unlock()
+		return &quotaRows{
+			cols: []string{"bytes", "objects"},
+			vals: [][]driver.Value{{c.st.reserved, c.st.sessions}},
+		}, nil
+
+	// The sessions read objects in a subquery, they are matched first.
+	case strings.Contains(query, "FROM `objects`"):
+		return &quotaRows{
+			cols: []string{"bytes", "objects"},
+			vals: [][]driver.Value{{int64(0), int64(0)}},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (c *quotaConn) ExecContext(
+	_ context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	if !strings.HasPrefix(query, "INSERT INTO `upload_sessions`") {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+
+	cols := query[strings.Index(query, "(")+1 : strings.Index(query, ") VALUES")]
+	values := make(map[string]int64)
+	for i, col := range strings.Split(cols, ",") {
+		switch v := args[i].Value.(type) {
+		case int64:
+			values[strings.Trim(col, "`")] = v
+		case uint64:
+			values[strings.Trim(col, "`")] = int64(v)
+		}
+	}
+
+	// Leave other transactions time to read the usage between the check
+	// and the insert of this one.
+	time.Sleep(5 * time.Millisecond)
+	c.st.mu.Lock()
+	defer c.st.mu.Unlock()
+	c.st.reserved += values["size"] * values["replicas"]
+	c.st.sessions++
+	c.st.nextID++
+	return quotaResult(c.st.nextID), nil
+}
+
+type quotaResult int64
+
+func (r quotaResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r quotaResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type quotaRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *quotaRows) Columns() []string {
+	return r.cols
+}
+
+func (r *quotaRows) Close() error {
+	return nil
+}
+
+func (r *quotaRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+func newQuotaDB(t *testing.T, st *quotaStore) *gorm.DB {
+	db, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      sql.OpenDB(st),
+			SkipInitializeWithVersion: true,
+		}),
+		&gorm.Config{
+			DisableAutomaticPing: true,
+			Logger:               logger.Discard,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestReserveSessionConcurrently(t *testing.T) {
+	cases := []struct {
+		name     string
+		size     uint64
+		replicas uint64
+		maxBytes uint64
+		sessions int
+		want     int
+	}{
+		{
+			name:     "single copy",
+			size:     30,
+			replicas: 1,
+			maxBytes: 100,
+			sessions: 10,
+			want:     3,
+		},
+		{
+			name:     "replicas charged",
+			size:     30,
+			replicas: 3,
+			maxBytes: 100,
+			sessions: 10,
+			want:     1,
+		},
+		{
+			name:     "no replica fits",
+			size:     30,
+			replicas: 4,
+			maxBytes: 100,
+			sessions: 5,
+			want:     0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &quotaStore{}
+			s := &Service{
+				db:       newQuotaDB(t, st),
+				quotaCfg: QuotaConfig{MaxBytes: tc.maxBytes},
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, tc.sessions)
+			for i := 0; i < tc.sessions; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- s.reserveSession(&orm.UploadSession{
+						SessionID: fmt.Sprintf("session-%d", i),
+						Account:   "alice",
+						Size:      tc.size,
+						Replicas:  tc.replicas,
+						Status:    orm.SessionOpen,
+					})
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			reserved := 0
+			for err := range errs {
+				switch err {
+				case nil:
+					reserved++
+				case errStorageQuotaExceeded:
+				default:
+					t.Fatalf("reserve session: %v", err)
+				}
+			}
+
+			if reserved != tc.want {
+				t.Fatalf("reserved sessions = %d, want %d", reserved, tc.want)
+			}
+
+			want := int64(tc.want) * int64(tc.size*tc.replicas)
+			if st.reserved != want {
+				t.Fatalf("reserved bytes = %d, want %d", st.reserved, want)
+			}
+		})
+	}
+}
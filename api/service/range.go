@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// errRangeNotSatisfiable is answered with a 416 status rather than
+// an error response.
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// byteRange is an inclusive byte window of an object.
+type byteRange struct {
+	start uint64
+	end   uint64
+}
+
+func (r *byteRange) length() uint64 {
+	return r.end - r.start + 1
+}
+
+// parseRange parses the Range header against an object of the given
+// size. Only a single byte range is supported, a nil range is returned
+// when the header is absent or should be ignored and the whole object
+// is served instead.
+func parseRange(header string, size uint64) (*byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, nil
+	}
+
+	spec := strings.TrimSpace(header[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, nil
+	}
+	startStr = strings.TrimSpace(startStr)
+	endStr = strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseUint(endStr, 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+
+		if n > size {
+			n = size
+		}
+
+		return &byteRange{start: size - n, end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseUint(endStr, 10, 64); err != nil {
+			return nil, nil
+		}
+
+		if end < start {
+			return nil, nil
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// ifRangeMatches reports whether the representation selected by the
+// If-Range precondition is still current. An absent header matches.
+func ifRangeMatches(c *gin.Context, etag string, modTime time.Time) bool {
+	v := c.GetHeader("If-Range")
+	if v == "" {
+		return true
+	}
+
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		// Weak validators must not be used for ranges.
+		return v == etag
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return false
+	}
+
+	return modTime.Truncate(time.Second).Equal(t)
+}
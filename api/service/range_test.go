@@ -0,0 +1,122 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		size   uint64
+		want   *byteRange
+		err    error
+	}{
+		{name: "absent", header: "", size: 100},
+		{name: "other unit", header: "items=0-1", size: 100},
+		{name: "multiple ranges", header: "bytes=0-1,5-6", size: 100},
+		{name: "malformed", header: "bytes=abc", size: 100},
+		{
+			name:   "closed",
+			header: "bytes=10-19",
+			size:   100,
+			want:   &byteRange{start: 10, end: 19},
+		},
+		{
+			name:   "open ended",
+			header: "bytes=90-",
+			size:   100,
+			want:   &byteRange{start: 90, end: 99},
+		},
+		{
+			name:   "end clamped",
+			header: "bytes=90-200",
+			size:   100,
+			want:   &byteRange{start: 90, end: 99},
+		},
+		{
+			name:   "suffix",
+			header: "bytes=-10",
+			size:   100,
+			want:   &byteRange{start: 90, end: 99},
+		},
+		{
+			name:   "suffix longer than object",
+			header: "bytes=-500",
+			size:   100,
+			want:   &byteRange{start: 0, end: 99},
+		},
+		{name: "end before start", header: "bytes=20-10", size: 100},
+		{
+			name:   "start past end",
+			header: "bytes=100-",
+			size:   100,
+			err:    errRangeNotSatisfiable,
+		},
+		{
+			name:   "empty suffix",
+			header: "bytes=-0",
+			size:   100,
+			err:    errRangeNotSatisfiable,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRange(tc.header, tc.size)
+			if err != tc.err {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+
+			if (got == nil) != (tc.want == nil) ||
+				(got != nil && *got != *tc.want) {
+				t.Fatalf("range = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	etag := `"abc"`
+	mod := time.Date(2022, 12, 1, 10, 0, 0, 500, time.UTC)
+	cases := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "absent", header: "", want: true},
+		{name: "same etag", header: `"abc"`, want: true},
+		{name: "other etag", header: `"def"`, want: false},
+		{name: "weak etag", header: `W/"abc"`, want: false},
+		{
+			name:   "same date",
+			header: mod.Format(http.TimeFormat),
+			want:   true,
+		},
+		{
+			name:   "other date",
+			header: mod.Add(time.Hour).Format(http.TimeFormat),
+			want:   false,
+		},
+		{name: "malformed date", header: "yesterday", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				c.Request.Header.Set("If-Range", tc.header)
+			}
+
+			if got := ifRangeMatches(c, etag, mod); got != tc.want {
+				t.Fatalf("match = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-common/log"
+	pbc "github.com/photon-storage/photon-proto/consensus"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+type retentionReq struct {
+	Retention string `json:"retention" binding:"required"`
+}
+
+// SetRetention handles the PUT /objects/:hash/retention request.
+func (s *Service) SetRetention(c *gin.Context, req *retentionReq) error {
+	r, ok := orm.ParseRetention(req.Retention)
+	if !ok {
+		return errInvalidRetention
+	}
+
+	o := &orm.Object{}
+	if err := s.db.Model(&orm.Object{}).
+		Where(
+			"account = ? and commit_tx_hash = ? and ref_id = ''",
+			accountOf(c),
+			c.Param("hash"),
+		).
+		First(o).Error; err != nil {
+		return err
+	}
+
+	// Objects signed by the owner's wallet can not be renewed on its
+	// behalf.
+	if r == orm.RetentionKeep {
+		if _, err := s.keys.KeyOf(o.OwnerPublicKey); err != nil {
+			return errRetentionNotSupported
+		}
+	}
+
+	return s.db.Model(&orm.Object{}).
+		Where("id = ?", o.ID).
+		Update("retention", r).
+		Error
+}
+
+// renewTask renews the storage contracts of the objects to keep before
+// they expire.
+type renewTask struct {
+	ctx     context.Context
+	db      *gorm.DB
+	nodeCli pbc.NodeClient
+	before  uint64
+	renew   func(*orm.Object) error
+}
+
+func newRenewTask(
+	ctx context.Context,
+	db *gorm.DB,
+	nodeCli pbc.NodeClient,
+	before uint64,
+	renew func(*orm.Object) error,
+) *renewTask {
+	return &renewTask{
+		ctx:     ctx,
+		db:      db,
+		nodeCli: nodeCli,
+		before:  before,
+		renew:   renew,
+	}
+}
+
+func (t *renewTask) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.renewObjects(); err != nil {
+				log.Error("renew objects failed", "error", err)
+			}
+
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *renewTask) renewObjects() error {
+	head, err := t.nodeCli.GetChainHead(t.ctx, &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+
+	os := make([]*orm.Object, 0)
+	if err := t.db.Model(&orm.Object{}).
+		Where(
+			"retention = ? and renewed_by = '' and ref_id = '' and status in (?,?)",
+			orm.RetentionKeep,
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+		).
+		Where(
+			"expiry_slot > 0 and expiry_slot <= ?",
+			uint64(head.HeadSlot)+t.before,
+		).
+		Limit(10).
+		Find(&os).
+		Error; err != nil {
+		return err
+	}
+
+	for _, o := range os {
+		if err := t.renew(o); err != nil {
+			log.Error("renew object failed",
+				"commit_tx_hash", o.CommitTxHash,
+				"error", err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// renewObject commits the content of o again for another period. There
+// is no transaction extending an existing storage contract, so the
+// content is read back from the depot and pushed under a new commit
+// transaction with the same parameters.
+func (s *Service) renewObject(o *orm.Object) error {
+	d, err := s.depots.pick()
+	if err != nil {
+		return err
+	}
+
+	renewal, err := s.recommit(o, d)
+	if err != nil {
+		return err
+	}
+
+	renewal.Retention = orm.RetentionKeep
+	renewal.RenewalOf = o.CommitTxHash
+	// The renewed object is unlisted first, so that the renewal can take
+	// its place.
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&orm.Object{}).
+			Where("id = ?", o.ID).
+			Update("renewed_by", renewal.CommitTxHash).
+			Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&orm.Object{}).Create(renewal).Error
+	})
+}
+
+// recommit commits the content of o to depot d with the parameters of
+// o. The stored chunks are streamed from the depot of o to d, the
+// content is committed as is without being decoded and encoded again.
+// The returned object is not saved.
+func (s *Service) recommit(o *orm.Object, d *depotClient) (*orm.Object, error) {
+	sk, err := s.keys.KeyOf(o.OwnerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.objectMeta(s.ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &storedChunks{s: s, m: m}
+	params := objectParams(o)
+	hash, err := s.commitFile(sk, d, params, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newObject(
+		placeOf(o),
+		o.OwnerPublicKey,
+		hash.Hex(),
+		d,
+		params,
+		objectEncoding(o),
+		src,
+	), nil
+}
+
+func objectParams(o *orm.Object) *commitParams {
+	return &commitParams{
+		Duration: o.Duration,
+		Fee:      o.Fee,
+		Pledge:   o.Pledge,
+		GasPrice: o.GasPrice,
+		Replicas: o.Replicas,
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-common/log"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// replicaBatchSize is the number of objects replicated per round.
+const replicaBatchSize = 10
+
+// replicaTask commits the objects requiring replicas to further depots
+// until each of them has the requested number of live copies. Objects
+// are visited in id order from where the previous round stopped, so
+// that objects failing to replicate do not hold back the others.
+type replicaTask struct {
+	ctx       context.Context
+	db        *gorm.DB
+	replicate func(*orm.Object) error
+	lastID    uint64
+}
+
+func newReplicaTask(
+	ctx context.Context,
+	db *gorm.DB,
+	replicate func(*orm.Object) error,
+) *replicaTask {
+	return &replicaTask{
+		ctx:       ctx,
+		db:        db,
+		replicate: replicate,
+	}
+}
+
+func (t *replicaTask) run() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.replicateObjects(); err != nil {
+				log.Error("replicate objects failed", "error", err)
+			}
+
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *replicaTask) replicateObjects() error {
+	os := make([]*orm.Object, 0)
+	if err := underReplicated(t.db, t.lastID).
+		Find(&os).
+		Error; err != nil {
+		return err
+	}
+
+	// Start over once the end is reached.
+	t.lastID = 0
+	if len(os) == replicaBatchSize {
+		t.lastID = os[len(os)-1].ID
+	}
+
+	for _, o := range os {
+		if err := t.replicate(o); err != nil {
+			log.Error("replicate object failed",
+				"commit_tx_hash", o.CommitTxHash,
+				"error", err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// underReplicated queries the next batch of primary objects after the
+// given id having fewer live replicas than requested.
+func underReplicated(db *gorm.DB, after uint64) *gorm.DB {
+	live := db.Table("objects AS r").
+		Select("count(*)").
+		Where("r.replica_of = objects.commit_tx_hash and r.ref_id = ''").
+		Where("r.status in (?,?,?)",
+			orm.ObjectPending,
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+		)
+
+	return db.Model(&orm.Object{}).
+		Where("replica_of = '' and ref_id = '' and replicas > 1 and status in (?,?)",
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+		).
+		Where("id > ?", after).
+		Where("(?) < replicas - 1", live).
+		Order("id").
+		Limit(replicaBatchSize)
+}
+
+// replicateObject commits a further copy of o to a depot holding none
+// of its live copies yet.
+func (s *Service) replicateObject(o *orm.Object) error {
+	copies, err := s.objectCopies(o)
+	if err != nil {
+		return err
+	}
+
+	exclude := make([]string, 0, len(copies))
+	for _, c := range copies {
+		if c.Status != orm.ObjectFailed && c.Status != orm.ObjectExpired {
+			exclude = append(exclude, c.DepotPublicKey)
+		}
+	}
+
+	d, err := s.depots.pick(exclude...)
+	if err != nil {
+		return err
+	}
+
+	replica, err := s.recommit(o, d)
+	if err != nil {
+		return err
+	}
+
+	replica.Replicas = 0
+	replica.ReplicaOf = o.CommitTxHash
+	return s.db.Model(&orm.Object{}).Create(replica).Error
+}
+
+// objectCopies returns the primary object of o followed by its replicas.
+func (s *Service) objectCopies(o *orm.Object) ([]*orm.Object, error) {
+	primary := o.CommitTxHash
+	if o.ReplicaOf != "" {
+		primary = o.ReplicaOf
+	}
+
+	copies := make([]*orm.Object, 0)
+	if err := s.db.Model(&orm.Object{}).
+		Where("(commit_tx_hash = ? or replica_of = ?) and ref_id = ''",
+			primary,
+			primary,
+		).
+		Order("id").
+		Find(&copies).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+// readableCopy returns the metadata of o, falling back to the other
+// copies of o when its depot is unavailable or fails to serve it. The
+// copies of the excluded ids are skipped.
+func (s *Service) readableCopy(
+	ctx context.Context,
+	o *orm.Object,
+	exclude ...uint64,
+) (*orm.Object, *objectMeta, error) {
+	err := errObjectNotReadable
+	if !containsID(exclude, o.ID) {
+		m, merr := s.objectMeta(ctx, o)
+		if merr == nil {
+			return o, m, nil
+		}
+		err = merr
+	}
+
+	if o.Replicas <= 1 && o.ReplicaOf == "" {
+		return nil, nil, err
+	}
+
+	copies, cerr := s.objectCopies(o)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+
+	for _, c := range copies {
+		if c.ID == o.ID || containsID(exclude, c.ID) ||
+			c.Status == orm.ObjectPending || c.Status == orm.ObjectFailed {
+			continue
+		}
+
+		cm, cerr := s.objectMeta(ctx, c)
+		if cerr != nil {
+			log.Error("read object replica failed",
+				"commit_tx_hash", c.CommitTxHash,
+				"error", cerr,
+			)
+			continue
+		}
+
+		return c, cm, nil
+	}
+
+	return nil, nil, err
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
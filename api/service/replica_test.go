@@ -0,0 +1,92 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(
+		mysql.New(mysql.Config{
+			DSN:                       "user:pass@tcp(127.0.0.1:3306)/dropbox",
+			SkipInitializeWithVersion: true,
+		}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestUnderReplicated(t *testing.T) {
+	cases := []struct {
+		name     string
+		after    uint64
+		contains []string
+		vars     []any
+	}{
+		{
+			name:  "first batch",
+			after: 0,
+			contains: []string{
+				"FROM `objects` WHERE",
+				"(SELECT count(*) FROM objects AS r WHERE " +
+					"(r.replica_of = objects.commit_tx_hash and r.ref_id = '')",
+				"id > ?",
+				"ORDER BY id LIMIT 10",
+			},
+			vars: []any{
+				orm.ObjectCommitted,
+				orm.ObjectFinalized,
+				uint64(0),
+				orm.ObjectPending,
+				orm.ObjectCommitted,
+				orm.ObjectFinalized,
+			},
+		},
+		{
+			name:     "next batch",
+			after:    42,
+			contains: []string{"id > ?"},
+			vars: []any{
+				orm.ObjectCommitted,
+				orm.ObjectFinalized,
+				uint64(42),
+				orm.ObjectPending,
+				orm.ObjectCommitted,
+				orm.ObjectFinalized,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			os := make([]*orm.Object, 0)
+			stmt := underReplicated(dryRunDB(t), tc.after).
+				Find(&os).
+				Statement
+			sql := stmt.SQL.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(sql, s) {
+					t.Fatalf("sql %q does not contain %q", sql, s)
+				}
+			}
+
+			if len(stmt.Vars) != len(tc.vars) {
+				t.Fatalf("vars = %v, want %v", stmt.Vars, tc.vars)
+			}
+			for i := range tc.vars {
+				if stmt.Vars[i] != tc.vars[i] {
+					t.Fatalf("vars = %v, want %v", stmt.Vars, tc.vars)
+				}
+			}
+		})
+	}
+}
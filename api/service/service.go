@@ -2,70 +2,160 @@ package service
 
 import (
 	"context"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"google.golang.org/protobuf/types/known/emptypb"
 	"gorm.io/gorm"
 
+	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-photon/config/config"
 	"github.com/photon-storage/go-photon/sak/io/rpc"
 	pbc "github.com/photon-storage/photon-proto/consensus"
-	pbd "github.com/photon-storage/photon-proto/depot"
 )
 
 // Service defines an instance of service that handles third-party requests.
 type Service struct {
-	ctx              context.Context
-	db               *gorm.DB
-	depotPk          []byte
-	depotDiscoveryID []byte
-	nodeCli          pbc.NodeClient
-	depotCli         pbd.DepotClient
+	ctx            context.Context
+	db             *gorm.DB
+	nodeCli        pbc.NodeClient
+	depots         *depotPool
+	sessionDir     string
+	nodeID         string
+	activeSessions sync.Map
+	downloadCfg    TransferConfig
+	uploadCfg      TransferConfig
+	commitCfg      CommitConfig
+	encodingCfg    EncodingConfig
+	encryptionCfg  EncryptionConfig
+	masterKey      []byte
+	authCfg        AuthConfig
+	tokenSecret    []byte
+	quotaCfg       QuotaConfig
+	dedup          bool
+	keys           KeyProvider
+	nonces         *nonceManager
 }
 
 // New creates a new service instance.
 func New(
 	ctx context.Context,
 	db *gorm.DB,
-	nodeEndpoint string,
 	configType config.ConfigType,
-	depotBootstrap []string,
+	cfg Config,
 ) (*Service, error) {
-	nc, err := rpcDialConfig(nodeEndpoint).Dial(ctx)
+	if err := os.MkdirAll(cfg.SessionDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "create session dir failed")
+	}
+
+	nodeID, err := cfg.nodeID()
 	if err != nil {
-		return nil, errors.Wrap(err, "dial node failed")
+		return nil, errors.Wrap(err, "resolve node id failed")
 	}
 
-	// TODO(doris): Async find multi depot client.
-	depotEndpoint, err := findDepot(ctx, configType, depotBootstrap)
+	encodingCfg := cfg.Encoding.withDefaults()
+	if err := encodingCfg.validate(); err != nil {
+		return nil, err
+	}
+
+	keys, err := newKeyProvider(db, configType, cfg.Keys)
 	if err != nil {
 		return nil, err
 	}
 
-	dc, err := rpcDialConfig(depotEndpoint).Dial(ctx)
+	masterKey, err := loadMasterKey(cfg.Encryption.MasterKeyFile)
 	if err != nil {
-		return nil, errors.Wrap(err, "dial depot node failed")
+		return nil, err
 	}
 
-	depotCli := pbd.NewDepotClient(dc)
-	depotState, err := depotCli.State(ctx, &emptypb.Empty{})
+	tokenSecret, err := loadTokenSecret(cfg.Auth.TokenSecretFile)
 	if err != nil {
 		return nil, err
 	}
 
+	nc, err := rpcDialConfig(cfg.NodeEndpoint).Dial(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial node failed")
+	}
+
+	depots := newDepotPool(ctx, cfg.DepotBootstrap, cfg.DepotEndpoints)
+	if err := depots.run(); err != nil {
+		return nil, err
+	}
+
+	if err := depots.wait(time.Minute); err != nil {
+		return nil, errors.Wrap(err, "find depot failed")
+	}
+
 	nodeCli := pbc.NewNodeClient(nc)
-	go newTxStatusTask(ctx, db, nodeCli).run()
-	go newCIDTask(ctx, db, depotCli).run()
-	return &Service{
-		ctx:              ctx,
-		db:               db,
-		depotPk:          depotState.GetPublicKey(),
-		depotDiscoveryID: depotState.GetDiscoveryId(),
-		nodeCli:          nodeCli,
-		depotCli:         depotCli,
-	}, nil
+	nonces := newNonceManager(nodeCli)
+	go newTxStatusTask(
+		ctx,
+		db,
+		nodeCli,
+		nonces,
+		cfg.slotDuration(),
+	).run()
+	go newCIDTask(ctx, db, depots).run()
+	s := &Service{
+		ctx:           ctx,
+		db:            db,
+		nodeCli:       nodeCli,
+		depots:        depots,
+		sessionDir:    cfg.SessionDir,
+		nodeID:        nodeID,
+		downloadCfg:   cfg.Download.withDefaults(),
+		uploadCfg:     cfg.Upload.withDefaults(),
+		commitCfg:     cfg.Commit.withDefaults(),
+		encodingCfg:   encodingCfg,
+		encryptionCfg: cfg.Encryption,
+		masterKey:     masterKey,
+		authCfg:       cfg.Auth.withDefaults(),
+		tokenSecret:   tokenSecret,
+		quotaCfg:      cfg.Quota,
+		dedup:         cfg.Dedup,
+		keys:          keys,
+		nonces:        nonces,
+	}
+	go newSessionTask(
+		ctx,
+		db,
+		cfg.SessionDir,
+		nodeID,
+		s.processUploadSession,
+	).run()
+	go newRenewTask(
+		ctx,
+		db,
+		nodeCli,
+		s.commitCfg.RenewBefore,
+		s.renewObject,
+	).run()
+	go newReplicaTask(ctx, db, s.replicateObject).run()
+	return s, nil
+}
+
+func newKeyProvider(
+	db *gorm.DB,
+	configType config.ConfigType,
+	cfg KeysConfig,
+) (KeyProvider, error) {
+	if cfg.Interop {
+		if configType != config.Devnet {
+			return nil, errors.New("interop keys are only allowed on devnet")
+		}
+
+		log.Warn("signing with the shared interop keys")
+		return newInteropKeys()
+	}
+
+	if cfg.KeystoreDir == "" {
+		return nil, errors.New("keystore dir is not configured")
+	}
+
+	return newKeystoreKeys(db, cfg.KeystoreDir, cfg.PasswordFile)
 }
 
 func rpcDialConfig(endpoint string) rpc.DialConfig {
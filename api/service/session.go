@@ -0,0 +1,915 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-photon/crypto/bls"
+	"github.com/photon-storage/go-photon/crypto/sha256"
+	"github.com/photon-storage/go-photon/depot"
+	pbc "github.com/photon-storage/photon-proto/consensus"
+	pbd "github.com/photon-storage/photon-proto/depot"
+
+	"github.com/photo-storage/dropbox/api/metrics"
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	blsPubkeyLength    = 48
+	blsSignatureLength = 96
+)
+
+type createSessionReq struct {
+	FileName string `json:"file_name" binding:"required"`
+	Size     uint64 `json:"size" binding:"required"`
+	// Path places the object in the account's folders, the top level
+	// folder and the file name are used when absent.
+	Path string `json:"path"`
+	// OwnerPublicKey switches the session to the client signed mode,
+	// the commit transaction is then signed by the owner's wallet.
+	OwnerPublicKey string `json:"owner_public_key"`
+	// Commit parameters overriding the configured defaults, the
+	// X-Dropbox-* headers are used when absent.
+	Duration uint64 `json:"duration"`
+	Fee      uint64 `json:"fee"`
+	Pledge   uint64 `json:"pledge"`
+	GasPrice uint64 `json:"gas_price"`
+	Replicas uint64 `json:"replicas"`
+	// Encoding, DataShards, ParityShards and SignBlocks override the
+	// configured upload encoding.
+	Encoding     string `json:"encoding"`
+	DataShards   uint32 `json:"data_shards"`
+	ParityShards uint32 `json:"parity_shards"`
+	SignBlocks   *bool  `json:"sign_blocks"`
+	// Encrypt overrides the configured default, session content can only
+	// be encrypted under the master key.
+	Encrypt *bool `json:"encrypt"`
+}
+
+type signSessionReq struct {
+	Signature string `json:"signature" binding:"required"`
+}
+
+type uploadSession struct {
+	SessionID    string `json:"session_id"`
+	FileName     string `json:"file_name"`
+	Size         uint64 `json:"size"`
+	Offset       uint64 `json:"offset"`
+	Status       string `json:"status"`
+	CommitTxHash string `json:"commit_tx_hash,omitempty"`
+	NumChunks    uint32 `json:"num_chunks"`
+	PushedChunks uint32 `json:"pushed_chunks"`
+	// UnsignedTx and its SSZ encoding are returned while the session is
+	// awaiting the owner's signature.
+	UnsignedTx *pbc.Transaction `json:"unsigned_tx,omitempty"`
+	TxSSZ      string           `json:"tx_ssz,omitempty"`
+}
+
+// CreateUploadSession handles the POST /upload/sessions request.
+func (s *Service) CreateUploadSession(
+	c *gin.Context,
+	req *createSessionReq,
+) (*uploadSession, error) {
+	fields := map[string]uint64{
+		"duration":  req.Duration,
+		"fee":       req.Fee,
+		"pledge":    req.Pledge,
+		"gas_price": req.GasPrice,
+		"replicas":  req.Replicas,
+	}
+	params, err := s.commitParams(func(name string) string {
+		if v := fields[name]; v != 0 {
+			return strconv.FormatUint(v, 10)
+		}
+		return c.GetHeader(commitHeader(name))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(name string) string {
+		switch {
+		case name == "encoding" && req.Encoding != "":
+			return req.Encoding
+		case name == "data_shards" && req.DataShards != 0:
+			return strconv.FormatUint(uint64(req.DataShards), 10)
+		case name == "parity_shards" && req.ParityShards != 0:
+			return strconv.FormatUint(uint64(req.ParityShards), 10)
+		case name == "sign_blocks" && req.SignBlocks != nil:
+			return strconv.FormatBool(*req.SignBlocks)
+		case name == "encrypt" && req.Encrypt != nil:
+			return strconv.FormatBool(*req.Encrypt)
+		}
+		return c.GetHeader(commitHeader(name))
+	}
+	enc, err := s.uploadEncoding(get)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data key has to be unwrapped again whenever the session is
+	// resumed, which is not possible with a key held by the client.
+	encr, err := s.uploadEncryption(get)
+	if err != nil {
+		return nil, err
+	}
+
+	if encr != nil && encr.keySource != keySourceMaster {
+		return nil, errUserKeyNotSupported
+	}
+
+	account := accountOf(c)
+	if err := s.checkQuota(s.db, account, req.Size, params.Replicas, 0); err != nil {
+		return nil, err
+	}
+
+	place, err := s.uploadPlace(account, req.Path, req.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	us := &orm.UploadSession{
+		SessionID:    hex.EncodeToString(id),
+		Node:         s.nodeID,
+		Account:      account,
+		DirectoryID:  place.dirID,
+		Name:         place.name,
+		Size:         req.Size,
+		Duration:     params.Duration,
+		Fee:          params.Fee,
+		Pledge:       params.Pledge,
+		GasPrice:     params.GasPrice,
+		Replicas:     params.Replicas,
+		Encoding:     enc.Scheme,
+		DataShards:   enc.DataShards,
+		ParityShards: enc.ParityShards,
+		SignBlocks:   enc.SignBlocks,
+		Status:       orm.SessionOpen,
+	}
+	if encr != nil {
+		us.WrappedKey = encr.wrappedKey
+	}
+	if req.OwnerPublicKey != "" {
+		pk, err := hex.DecodeString(req.OwnerPublicKey)
+		if err != nil || len(pk) != blsPubkeyLength {
+			return nil, errInvalidPublicKey
+		}
+
+		// Replicas are signed by the service with the owner's key.
+		if params.Replicas > 1 {
+			return nil, errReplicationNotSupported
+		}
+
+		// Blocks are signed with the owner key, which stays with the
+		// client.
+		if enc.SignBlocks {
+			return nil, errSignBlocksNotSupported
+		}
+
+		us.OwnerPublicKey = hex.EncodeToString(pk)
+		us.ClientSigned = true
+	}
+
+	f, err := os.Create(s.sessionPath(us.SessionID))
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	if err := s.reserveSession(us); err != nil {
+		s.removeSessionFile(us)
+		return nil, err
+	}
+
+	return toUploadSession(us)
+}
+
+// reserveSession creates us, which reserves its size in the quota of
+// the account. The quota is checked again along with the reservation,
+// concurrent sessions of the account are created one at a time.
+func (s *Service) reserveSession(us *orm.UploadSession) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkQuota(
+			tx,
+			us.Account,
+			us.Size,
+			us.Replicas,
+			0,
+		); err != nil {
+			return err
+		}
+
+		return tx.Model(&orm.UploadSession{}).Create(us).Error
+	})
+}
+
+// UploadSession handles the GET /upload/sessions/:id request.
+func (s *Service) UploadSession(c *gin.Context) (*uploadSession, error) {
+	us, err := s.findUploadSession(accountOf(c), c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return toUploadSession(us)
+}
+
+// UploadSessionPart handles the PUT /upload/sessions/:id request. The
+// request body is written at the given offset, which must be equal to
+// the number of bytes received so far.
+func (s *Service) UploadSessionPart(c *gin.Context) (*uploadSession, error) {
+	us, err := s.findUploadSession(accountOf(c), c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if us.Status != orm.SessionOpen {
+		return nil, errSessionNotOpen
+	}
+
+	if err := s.checkSessionNode(us); err != nil {
+		return nil, err
+	}
+
+	offset, err := strconv.ParseUint(c.Query("offset"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != us.Received {
+		return nil, errSessionOffsetMismatch
+	}
+
+	// Claim the offset before writing so that concurrent writers do not
+	// overwrite the bytes of each other.
+	res := s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ? and received = ?",
+			us.ID,
+			orm.SessionOpen,
+			offset,
+		).
+		Update("status", orm.SessionReceiving)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, errSessionOffsetMismatch
+	}
+
+	// The bytes received before a failure are kept, the client resumes
+	// from the offset reported by UploadSession.
+	n, werr := s.writeSessionPart(us, c.Request.Body)
+	if err := s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ?", us.ID, orm.SessionReceiving).
+		Updates(map[string]any{
+			"received": us.Received + n,
+			"status":   orm.SessionOpen,
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	if werr != nil {
+		return nil, werr
+	}
+
+	us.Received += n
+	return toUploadSession(us)
+}
+
+// writeSessionPart writes r at the received offset of the staged file
+// of us. It returns the number of bytes written and synced to disk,
+// which is also set when writing fails. A part past the size of the
+// session is dropped as a whole, the file is truncated back to the
+// received offset.
+func (s *Service) writeSessionPart(
+	us *orm.UploadSession,
+	r io.Reader,
+) (uint64, error) {
+	f, err := os.OpenFile(s.sessionPath(us.SessionID), os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(int64(us.Received), io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := int64(us.Size - us.Received)
+	n, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	metrics.UploadBytes.Add(float64(n))
+	if n > remaining {
+		if err := f.Truncate(int64(us.Received)); err != nil {
+			return 0, err
+		}
+
+		return 0, errSessionSizeExceeded
+	}
+
+	if serr := f.Sync(); serr != nil {
+		return 0, serr
+	}
+
+	return uint64(n), err
+}
+
+// CompleteUploadSession handles the POST /upload/sessions/:id/complete
+// request. The object is committed and pushed to the depot in the
+// background, the progress can be queried with UploadSession. A client
+// signed session returns the unsigned commit transaction instead and
+// waits for SignUploadSession.
+func (s *Service) CompleteUploadSession(c *gin.Context) (*uploadSession, error) {
+	us, err := s.findUploadSession(accountOf(c), c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if us.Status != orm.SessionOpen {
+		return nil, errSessionNotOpen
+	}
+
+	if us.Received != us.Size {
+		return nil, errSessionIncomplete
+	}
+
+	if err := s.checkSessionNode(us); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkQuota(
+		s.db,
+		us.Account,
+		us.Size,
+		us.Replicas,
+		us.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	if us.ClientSigned {
+		if err := s.prepareClientTx(us); err != nil {
+			return nil, err
+		}
+
+		return toUploadSession(us)
+	}
+
+	if err := s.updateSessionStatus(
+		us,
+		orm.SessionOpen,
+		orm.SessionCommitting,
+	); err != nil {
+		return nil, err
+	}
+
+	resp, err := toUploadSession(us)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.processUploadSession(us)
+	return resp, nil
+}
+
+// SignUploadSession handles the POST /upload/sessions/:id/signature
+// request carrying the owner's signature of the commit transaction.
+// The upload is initialized right away so that an invalid signature is
+// reported to the client, the chunks are pushed in the background.
+func (s *Service) SignUploadSession(
+	c *gin.Context,
+	req *signSessionReq,
+) (*uploadSession, error) {
+	us, err := s.findUploadSession(accountOf(c), c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if us.Status != orm.SessionAwaitingSignature {
+		return nil, errSessionNotAwaitingSignature
+	}
+
+	if err := s.checkSessionNode(us); err != nil {
+		return nil, err
+	}
+
+	sig, err := hex.DecodeString(req.Signature)
+	if err != nil || len(sig) != blsSignatureLength {
+		return nil, errInvalidSignature
+	}
+
+	tx := &pbc.SignedTransaction{}
+	if err := proto.Unmarshal(us.SignedTx, tx); err != nil {
+		return nil, err
+	}
+	tx.Signature = sig
+
+	d, err := s.txDepot(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.initUpload(d, tx); err != nil {
+		return nil, err
+	}
+
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ?", us.ID, orm.SessionAwaitingSignature).
+		Updates(map[string]any{
+			"signed_tx": raw,
+			"status":    orm.SessionCommitting,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, errSessionStateChanged
+	}
+	us.SignedTx = raw
+	us.Status = orm.SessionCommitting
+
+	resp, err := toUploadSession(us)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.processUploadSession(us)
+	return resp, nil
+}
+
+// AbortUploadSession handles the DELETE /upload/sessions/:id request.
+func (s *Service) AbortUploadSession(c *gin.Context) (*uploadSession, error) {
+	us, err := s.findUploadSession(accountOf(c), c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if us.Status != orm.SessionOpen {
+		return nil, errSessionNotOpen
+	}
+
+	if err := s.checkSessionNode(us); err != nil {
+		return nil, err
+	}
+
+	if err := s.updateSessionStatus(
+		us,
+		orm.SessionOpen,
+		orm.SessionAborted,
+	); err != nil {
+		return nil, err
+	}
+
+	s.removeSessionFile(us)
+	return toUploadSession(us)
+}
+
+// processUploadSession commits a session and pushes its content to the
+// depot. It is safe to be called multiple times for the same session,
+// concurrent calls are dropped and completed steps are skipped.
+func (s *Service) processUploadSession(us *orm.UploadSession) {
+	if _, loaded := s.activeSessions.LoadOrStore(us.ID, nil); loaded {
+		return
+	}
+	defer s.activeSessions.Delete(us.ID)
+
+	if err := s.commitUploadSession(us); err != nil {
+		log.Error("commit upload session failed",
+			"session", us.SessionID,
+			"attempts", us.Attempts+1,
+			"error", err,
+		)
+
+		if err := s.deferUploadSession(us, err); err != nil {
+			log.Error("defer upload session failed",
+				"session", us.SessionID,
+				"error", err,
+			)
+		}
+	}
+}
+
+// deferUploadSession schedules the next commit attempt of us with an
+// exponential backoff. The session is marked failed instead when err
+// can not be recovered by retrying or the attempts are exhausted.
+func (s *Service) deferUploadSession(us *orm.UploadSession, err error) error {
+	if isPermanent(err) || us.Attempts+1 >= maxSessionAttempts {
+		if err := s.updateSessionStatus(
+			us,
+			orm.SessionCommitting,
+			orm.SessionFailed,
+		); err != nil {
+			return err
+		}
+
+		s.removeSessionFile(us)
+		return nil
+	}
+
+	next := time.Now().Add(sessionBackoff(us.Attempts))
+	return s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ?", us.ID, orm.SessionCommitting).
+		Updates(map[string]any{
+			"attempts":        us.Attempts + 1,
+			"next_attempt_at": next,
+		}).Error
+}
+
+// prepareClientTx builds the unsigned commit transaction of a client
+// signed session and stores it until the signature is submitted.
+func (s *Service) prepareClientTx(us *orm.UploadSession) error {
+	uf, err := s.sessionUploadFile(us, nil)
+	if err != nil {
+		return err
+	}
+
+	pk, err := hex.DecodeString(us.OwnerPublicKey)
+	if err != nil {
+		return err
+	}
+
+	acct, err := s.nodeCli.GetAccount(
+		s.ctx,
+		&pbc.AccountRequest{Address: pk},
+	)
+	if err != nil {
+		return err
+	}
+
+	d, err := s.depots.pick()
+	if err != nil {
+		return err
+	}
+
+	tx, hash, err := s.buildCommitTx(pk, acct.Nonce, d, sessionParams(us), uf)
+	if err != nil {
+		return err
+	}
+
+	raw, err := proto.Marshal(&pbc.SignedTransaction{Tx: tx})
+	if err != nil {
+		return err
+	}
+
+	res := s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ?", us.ID, orm.SessionOpen).
+		Updates(map[string]any{
+			"commit_tx_hash": hash.Hex(),
+			"signed_tx":      raw,
+			"num_chunks":     uf.NumChunks(),
+			"status":         orm.SessionAwaitingSignature,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return errSessionStateChanged
+	}
+
+	us.CommitTxHash = hash.Hex()
+	us.SignedTx = raw
+	us.NumChunks = uf.NumChunks()
+	us.Status = orm.SessionAwaitingSignature
+	return nil
+}
+
+// sessionUploadFile prepares the staged content of us, sk signs the
+// blocks if requested and may be nil for client signed sessions.
+func (s *Service) sessionUploadFile(
+	us *orm.UploadSession,
+	sk bls.SecretKey,
+) (*depot.UploadFile, error) {
+	f, err := os.Open(s.sessionPath(us.SessionID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The segment nonces are derived from the data key, the content is
+	// encrypted the same way every time the session is resumed. Only the
+	// declared size is read, whatever is past it is not content.
+	var src io.Reader = io.LimitReader(f, int64(us.Size))
+	if len(us.WrappedKey) > 0 {
+		if s.masterKey == nil {
+			return nil, errEncryptionNotConfigured
+		}
+
+		dk, err := unwrapKey(s.masterKey, us.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if src, err = newEncryptReader(src, dk); err != nil {
+			return nil, err
+		}
+	}
+
+	return newUploadFile(src, sk, sessionEncoding(us))
+}
+
+// sessionKey returns the key signing the commit tx of a custodial
+// session, which is the one already used if the tx has been built.
+func (s *Service) sessionKey(us *orm.UploadSession) (bls.SecretKey, error) {
+	if us.OwnerPublicKey != "" {
+		return s.keys.KeyOf(us.OwnerPublicKey)
+	}
+
+	return s.keys.Key(us.Account)
+}
+
+func (s *Service) commitUploadSession(us *orm.UploadSession) error {
+	var sk bls.SecretKey
+	if !us.ClientSigned {
+		k, err := s.sessionKey(us)
+		if err != nil {
+			return err
+		}
+		sk = k
+	}
+
+	uf, err := s.sessionUploadFile(us, sk)
+	if err != nil {
+		return err
+	}
+
+	tx := &pbc.SignedTransaction{}
+	hash := sha256.Zero
+	if len(us.SignedTx) == 0 {
+		// Persist the signed transaction before initializing the upload
+		// so that it is reused rather than wasted if the process stops.
+		d, err := s.depots.pick()
+		if err != nil {
+			return err
+		}
+
+		tx, hash, err = s.buildSignedCommitTx(sk, d, sessionParams(us), uf)
+		if err != nil {
+			return err
+		}
+
+		raw, err := proto.Marshal(tx)
+		if err != nil {
+			return err
+		}
+
+		us.OwnerPublicKey = sk.PublicKey().Hex()
+		us.CommitTxHash = hash.Hex()
+		us.SignedTx = raw
+		us.NumChunks = uf.NumChunks()
+		if err := s.db.Model(&orm.UploadSession{}).
+			Where("id = ?", us.ID).
+			Updates(map[string]any{
+				"owner_public_key": us.OwnerPublicKey,
+				"commit_tx_hash":   us.CommitTxHash,
+				"signed_tx":        us.SignedTx,
+				"num_chunks":       us.NumChunks,
+			}).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := proto.Unmarshal(us.SignedTx, tx); err != nil {
+			return err
+		}
+
+		if hash, err = sha256.HashFromHex(us.CommitTxHash); err != nil {
+			return err
+		}
+	}
+	uf.SetTxHash(hash)
+
+	// The object can only be pushed to the depot named in the tx, the
+	// session is resumed once it is available again.
+	d, err := s.txDepot(tx)
+	if err != nil {
+		return err
+	}
+
+	objStatus := pbd.ObjectStatus_NOT_FOUND
+	var received *pbd.BitSet
+	objResp, err := d.cli.ObjectStatus(s.ctx, &pbd.ObjectStatusRequest{
+		Hash:         uf.OriginalHash().Bytes(),
+		CommitTxHash: hash.Bytes(),
+	})
+	if err == nil {
+		objStatus = objResp.Status
+		received = objResp.Received
+	} else if status.Convert(err).Code() != codes.NotFound {
+		return err
+	}
+
+	switch objStatus {
+	case pbd.ObjectStatus_READABLE:
+		// All chunks have been received by the depot.
+
+	case pbd.ObjectStatus_WRITABLE:
+		if err := s.pushChunks(
+			d,
+			fileChunks{uf},
+			hash,
+			received,
+			s.sessionProgress(us),
+		); err != nil {
+			return err
+		}
+
+	default:
+		if err := s.initUpload(d, tx); err != nil {
+			return err
+		}
+
+		if err := s.pushChunks(
+			d,
+			fileChunks{uf},
+			hash,
+			nil,
+			s.sessionProgress(us),
+		); err != nil {
+			return err
+		}
+	}
+
+	count := int64(0)
+	if err := s.db.Model(&orm.Object{}).
+		Where("commit_tx_hash = ? and ref_id = ''", us.CommitTxHash).
+		Count(&count).
+		Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if len(us.WrappedKey) > 0 {
+			if err := s.saveObjectKey(
+				uf.OriginalHash().Hex(),
+				&objectEncryption{
+					wrappedKey: us.WrappedKey,
+					keySource:  keySourceMaster,
+				},
+				us.Size,
+			); err != nil {
+				return err
+			}
+		}
+
+		place := &objectPlace{
+			account: us.Account,
+			dirID:   us.DirectoryID,
+			name:    us.Name,
+		}
+		o := s.newObject(
+			place,
+			us.OwnerPublicKey,
+			us.CommitTxHash,
+			d,
+			sessionParams(us),
+			sessionEncoding(us),
+			fileChunks{uf},
+		)
+		o.ClientSigned = us.ClientSigned
+		if err := s.insertObject(place, us.ID, o); err != nil {
+			return err
+		}
+	}
+
+	if err := s.updateSessionStatus(
+		us,
+		orm.SessionCommitting,
+		orm.SessionCompleted,
+	); err != nil {
+		return err
+	}
+
+	s.removeSessionFile(us)
+	return nil
+}
+
+func sessionParams(us *orm.UploadSession) *commitParams {
+	return &commitParams{
+		Duration: us.Duration,
+		Fee:      us.Fee,
+		Pledge:   us.Pledge,
+		GasPrice: us.GasPrice,
+		Replicas: us.Replicas,
+	}
+}
+
+func (s *Service) sessionProgress(us *orm.UploadSession) func(uint32) error {
+	return func(pushed uint32) error {
+		us.PushedChunks = pushed
+		return s.db.Model(&orm.UploadSession{}).
+			Where("id = ?", us.ID).
+			Update("pushed_chunks", pushed).
+			Error
+	}
+}
+
+func (s *Service) findUploadSession(
+	account string,
+	id string,
+) (*orm.UploadSession, error) {
+	us := &orm.UploadSession{}
+	if err := s.db.Model(&orm.UploadSession{}).
+		Where("account = ? and session_id = ?", account, id).
+		First(us).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return us, nil
+}
+
+func (s *Service) updateSessionStatus(
+	us *orm.UploadSession,
+	from orm.UploadSessionStatus,
+	to orm.UploadSessionStatus,
+) error {
+	res := s.db.Model(&orm.UploadSession{}).
+		Where("id = ? and status = ?", us.ID, from).
+		Update("status", to)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return errSessionStateChanged
+	}
+
+	us.Status = to
+	return nil
+}
+
+// checkSessionNode fails the requests needing the staged content of us
+// when it is on another node.
+func (s *Service) checkSessionNode(us *orm.UploadSession) error {
+	if us.Node != s.nodeID {
+		return errSessionOtherNode
+	}
+
+	return nil
+}
+
+func (s *Service) sessionPath(id string) string {
+	return filepath.Join(s.sessionDir, id)
+}
+
+func (s *Service) removeSessionFile(us *orm.UploadSession) {
+	if err := os.Remove(s.sessionPath(us.SessionID)); err != nil &&
+		!os.IsNotExist(err) {
+		log.Error("remove session file failed",
+			"session", us.SessionID,
+			"error", err,
+		)
+	}
+}
+
+func toUploadSession(us *orm.UploadSession) (*uploadSession, error) {
+	resp := &uploadSession{
+		SessionID:    us.SessionID,
+		FileName:     us.Name,
+		Size:         us.Size,
+		Offset:       us.Received,
+		Status:       us.Status.String(),
+		CommitTxHash: us.CommitTxHash,
+		NumChunks:    us.NumChunks,
+		PushedChunks: us.PushedChunks,
+	}
+
+	if us.Status == orm.SessionAwaitingSignature {
+		tx := &pbc.SignedTransaction{}
+		if err := proto.Unmarshal(us.SignedTx, tx); err != nil {
+			return nil, err
+		}
+
+		raw, err := tx.Tx.MarshalSSZ()
+		if err != nil {
+			return nil, err
+		}
+
+		resp.UnsignedTx = tx.Tx
+		resp.TxSSZ = hex.EncodeToString(raw)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-common/log"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	// sessionTimeout is the period of inactivity after which an open or
+	// unsigned session is aborted and a committing session is marked
+	// failed.
+	sessionTimeout = 24 * time.Hour
+
+	// maxSessionAttempts is the number of commit attempts after which
+	// a committing session is marked failed.
+	maxSessionAttempts = 30
+	minSessionBackoff  = 10 * time.Second
+	maxSessionBackoff  = time.Hour
+)
+
+type sessionTask struct {
+	ctx        context.Context
+	db         *gorm.DB
+	sessionDir string
+	nodeID     string
+	process    func(*orm.UploadSession)
+}
+
+func newSessionTask(
+	ctx context.Context,
+	db *gorm.DB,
+	sessionDir string,
+	nodeID string,
+	process func(*orm.UploadSession),
+) *sessionTask {
+	return &sessionTask{
+		ctx:        ctx,
+		db:         db,
+		sessionDir: sessionDir,
+		nodeID:     nodeID,
+		process:    process,
+	}
+}
+
+func (t *sessionTask) run() {
+	if err := t.releaseParts(); err != nil {
+		log.Error("release upload session parts failed", "error", err)
+	}
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.expireSessions(); err != nil {
+				log.Error("expire upload sessions failed", "error", err)
+			}
+
+			if err := t.resumeSessions(); err != nil {
+				log.Error("resume upload sessions failed", "error", err)
+			}
+
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// releaseParts reopens the sessions of this node left receiving a part
+// by a previous process, the bytes past the persisted offset are
+// written again by the client.
+func (t *sessionTask) releaseParts() error {
+	return t.db.Model(&orm.UploadSession{}).
+		Where("node = ? and status = ?", t.nodeID, orm.SessionReceiving).
+		Update("status", orm.SessionOpen).
+		Error
+}
+
+// resumeSessions picks up the committing sessions of this node,
+// including the ones that were interrupted by a restart, and continues
+// pushing their chunks. The content of the sessions of other nodes is
+// not on this node.
+func (t *sessionTask) resumeSessions() error {
+	uss := make([]*orm.UploadSession, 0)
+	if err := t.db.Model(&orm.UploadSession{}).
+		Where("node = ? and status = ? and updated_at > ?",
+			t.nodeID,
+			orm.SessionCommitting,
+			time.Now().Add(-sessionTimeout),
+		).
+		Where("next_attempt_at is null or next_attempt_at <= ?", time.Now()).
+		Limit(10).
+		Find(&uss).
+		Error; err != nil {
+		return err
+	}
+
+	for _, us := range uss {
+		t.process(us)
+	}
+
+	return nil
+}
+
+// sessionBackoff returns the delay before the next commit attempt after
+// the given number of failed attempts.
+func sessionBackoff(attempts uint32) time.Duration {
+	d := minSessionBackoff
+	for i := uint32(0); i < attempts && d < maxSessionBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxSessionBackoff {
+		return maxSessionBackoff
+	}
+
+	return d
+}
+
+// isPermanent reports whether err fails a session commit for good. The
+// signing key missing, the place taken, the quota exceeded and requests
+// refused by the depot or the node are not recovered by retrying. A
+// missing staged file is retried until the attempts are exhausted like
+// any other error.
+func isPermanent(err error) bool {
+	switch errors.Cause(err) {
+	case errKeyNotFound,
+		errPathExists,
+		errStorageQuotaExceeded,
+		errObjectQuotaExceeded:
+		return true
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument,
+		codes.FailedPrecondition,
+		codes.AlreadyExists,
+		codes.PermissionDenied,
+		codes.Unauthenticated,
+		codes.OutOfRange,
+		codes.Unimplemented:
+		return true
+	}
+
+	return false
+}
+
+func (t *sessionTask) expireSessions() error {
+	uss := make([]*orm.UploadSession, 0)
+	if err := t.db.Model(&orm.UploadSession{}).
+		Where("status in (?,?,?,?) and updated_at < ?",
+			orm.SessionOpen,
+			orm.SessionReceiving,
+			orm.SessionAwaitingSignature,
+			orm.SessionCommitting,
+			time.Now().Add(-sessionTimeout),
+		).
+		Limit(10).
+		Find(&uss).
+		Error; err != nil {
+		return err
+	}
+
+	for _, us := range uss {
+		status := orm.SessionAborted
+		if us.Status == orm.SessionCommitting {
+			status = orm.SessionFailed
+		}
+
+		if err := t.db.Model(&orm.UploadSession{}).
+			Where("id = ? and status = ?", us.ID, us.Status).
+			Update("status", status).
+			Error; err != nil {
+			return err
+		}
+
+		// The content of a session is only on the node owning it, the
+		// sessions of a node gone for good are still expired by others
+		// so that their quota reservation is released.
+		if us.Node != t.nodeID {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(t.sessionDir, us.SessionID)); err != nil &&
+			!os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
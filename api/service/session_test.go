@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+// failingReader returns data then fails, like a dropped connection.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func newSessionService(t *testing.T, us *orm.UploadSession) *Service {
+	s := &Service{sessionDir: t.TempDir(), nodeID: "node-a"}
+	f, err := os.Create(s.sessionPath(us.SessionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return s
+}
+
+func readSessionFile(t *testing.T, s *Service, us *orm.UploadSession) string {
+	data, err := os.ReadFile(s.sessionPath(us.SessionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestWriteSessionPartOverflow(t *testing.T) {
+	us := &orm.UploadSession{SessionID: "overflow", Size: 8}
+	s := newSessionService(t, us)
+
+	n, err := s.writeSessionPart(us, strings.NewReader("abcde"))
+	if err != nil || n != 5 {
+		t.Fatalf("first part: got (%d, %v), want (5, nil)", n, err)
+	}
+	us.Received += n
+
+	// Four bytes where three are left, the part is dropped as a whole.
+	n, err = s.writeSessionPart(us, strings.NewReader("fghi"))
+	if err != errSessionSizeExceeded || n != 0 {
+		t.Fatalf(
+			"oversize part: got (%d, %v), want (0, %v)",
+			n,
+			err,
+			errSessionSizeExceeded,
+		)
+	}
+
+	if got := readSessionFile(t, s, us); got != "abcde" {
+		t.Fatalf("staged file after overflow = %q, want %q", got, "abcde")
+	}
+
+	n, err = s.writeSessionPart(us, strings.NewReader("fgh"))
+	if err != nil || n != 3 {
+		t.Fatalf("last part: got (%d, %v), want (3, nil)", n, err)
+	}
+	us.Received += n
+
+	if got := readSessionFile(t, s, us); got != "abcdefgh" {
+		t.Fatalf("staged file = %q, want %q", got, "abcdefgh")
+	}
+}
+
+func TestWriteSessionPartResume(t *testing.T) {
+	us := &orm.UploadSession{SessionID: "resume", Size: 10}
+	s := newSessionService(t, us)
+
+	// The connection drops after four bytes, they are kept.
+	n, err := s.writeSessionPart(us, &failingReader{data: []byte("0123")})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != 4 {
+		t.Fatalf(
+			"dropped part: got (%d, %v), want (4, %v)",
+			n,
+			err,
+			io.ErrUnexpectedEOF,
+		)
+	}
+	us.Received += n
+
+	// The client resumes from the reported offset.
+	n, err = s.writeSessionPart(us, strings.NewReader("456789"))
+	if err != nil || n != 6 {
+		t.Fatalf("resumed part: got (%d, %v), want (6, nil)", n, err)
+	}
+	us.Received += n
+
+	if got := readSessionFile(t, s, us); got != "0123456789" {
+		t.Fatalf("staged file = %q, want %q", got, "0123456789")
+	}
+}
+
+func TestSessionNodeOwnership(t *testing.T) {
+	s := &Service{nodeID: "node-a"}
+	if err := s.checkSessionNode(&orm.UploadSession{Node: "node-a"}); err != nil {
+		t.Fatalf("own session: got %v, want nil", err)
+	}
+
+	if err := s.checkSessionNode(
+		&orm.UploadSession{Node: "node-b"},
+	); err != errSessionOtherNode {
+		t.Fatalf("other session: got %v, want %v", err, errSessionOtherNode)
+	}
+
+	if isPermanent(&os.PathError{Op: "open", Err: os.ErrNotExist}) {
+		t.Fatal("a missing staged file must be retried")
+	}
+}
+
+// captureSQL records the statements run on db.
+func captureSQL(t *testing.T, db *gorm.DB) *[]string {
+	stmts := make([]string, 0)
+	capture := func(tx *gorm.DB) {
+		stmts = append(stmts, tx.Dialector.Explain(
+			tx.Statement.SQL.String(),
+			tx.Statement.Vars...,
+		))
+	}
+
+	if err := db.Callback().Query().
+		After("gorm:query").
+		Register("test:capture", capture); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Callback().Update().
+		After("gorm:update").
+		Register("test:capture", capture); err != nil {
+		t.Fatal(err)
+	}
+
+	return &stmts
+}
+
+func TestSessionTaskScopedToNode(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	stmts := captureSQL(t, db)
+	task := newSessionTask(
+		context.Background(),
+		db,
+		t.TempDir(),
+		"node-a",
+		func(*orm.UploadSession) {},
+	)
+
+	if err := task.releaseParts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := task.resumeSessions(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*stmts) != 2 {
+		t.Fatalf("statements = %q, want 2", *stmts)
+	}
+
+	for _, sql := range *stmts {
+		if !strings.Contains(sql, "node = 'node-a'") {
+			t.Fatalf("statement %q is not scoped to the node", sql)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const tokenSecretSize = 32
+
+// jwtHeader is the encoded header of every issued token, only HS256
+// is accepted.
+var jwtHeader = base64.RawURLEncoding.EncodeToString(
+	[]byte(`{"alg":"HS256","typ":"JWT"}`),
+)
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	PublicKey string `json:"pk,omitempty"`
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type tokenResp struct {
+	Token     string   `json:"token"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt uint64   `json:"expires_at"`
+}
+
+// IssueToken handles the POST /auth/token request, which exchanges
+// the credentials of the request for a bearer token of the same
+// scopes.
+func (s *Service) IssueToken(c *gin.Context) (*tokenResp, error) {
+	p := PrincipalOf(c)
+	now := time.Now()
+	claims := &tokenClaims{
+		Subject:   p.Account,
+		Scope:     strings.Join(p.Scopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.authCfg.TokenTTL).Unix(),
+	}
+
+	token, err := signToken(s.tokenSecret, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResp{
+		Token:     token,
+		Scopes:    p.Scopes,
+		ExpiresAt: uint64(claims.ExpiresAt),
+	}, nil
+}
+
+// signToken encodes claims as an HS256 JSON web token.
+func signToken(secret []byte, claims *tokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(
+		tokenMAC(secret, signed),
+	), nil
+}
+
+// parseToken verifies the signature and the expiry of an HS256 JSON
+// web token and returns its claims.
+func parseToken(secret []byte, token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, errUnauthenticated
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+
+	if !hmac.Equal(sig, tokenMAC(secret, parts[0]+"."+parts[1])) {
+		return nil, errUnauthenticated
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errUnauthenticated
+	}
+
+	if claims.Subject == "" || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errUnauthenticated
+	}
+
+	return claims, nil
+}
+
+func tokenMAC(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// loadTokenSecret reads the token signing key, which is required so
+// that the issued tokens stay valid across restarts and between the
+// nodes sharing it.
+func loadTokenSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("token secret file is not configured")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read token secret failed")
+	}
+
+	secret, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(secret) < tokenSecretSize {
+		return nil, errors.New("token secret must be at least 32 hex encoded bytes")
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	secret := []byte(strings.Repeat("s", tokenSecretSize))
+	now := time.Now()
+	valid := &tokenClaims{
+		Subject:   "alice",
+		Scope:     "read write",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+	sign := func(secret []byte, c *tokenClaims) string {
+		token, err := signToken(secret, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := *valid
+	expired.ExpiresAt = now.Add(-time.Second).Unix()
+	anonymous := *valid
+	anonymous.Subject = ""
+	token := sign(secret, valid)
+	parts := strings.Split(token, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"alg":"none","typ":"JWT"}`),
+	)
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "valid", token: token, ok: true},
+		{name: "expired", token: sign(secret, &expired)},
+		{name: "no subject", token: sign(secret, &anonymous)},
+		{name: "other secret", token: sign([]byte("other"), valid)},
+		{name: "empty", token: ""},
+		{name: "two parts", token: parts[0] + "." + parts[1]},
+		{
+			name:  "alg none",
+			token: noneHeader + "." + parts[1] + ".",
+		},
+		{
+			name:  "tampered payload",
+			token: parts[0] + "." + parts[1] + "x." + parts[2],
+		},
+		{
+			name:  "malformed signature",
+			token: parts[0] + "." + parts[1] + ".!!",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := parseToken(secret, tc.token)
+			if !tc.ok {
+				if err != errUnauthenticated {
+					t.Fatalf("error = %v, want %v", err, errUnauthenticated)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *claims != *valid {
+				t.Fatalf("claims = %+v, want %+v", claims, valid)
+			}
+		})
+	}
+}
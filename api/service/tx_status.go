@@ -8,11 +8,13 @@ import (
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"gorm.io/gorm"
 
 	"github.com/photon-storage/go-common/log"
 	pbc "github.com/photon-storage/photon-proto/consensus"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/database/orm"
 )
 
@@ -20,17 +22,23 @@ type txStatusTask struct {
 	ctx     context.Context
 	db      *gorm.DB
 	nodeCli pbc.NodeClient
+	nonces  *nonceManager
+	slotDur time.Duration
 }
 
 func newTxStatusTask(
 	ctx context.Context,
 	db *gorm.DB,
 	nodeCli pbc.NodeClient,
+	nonces *nonceManager,
+	slotDur time.Duration,
 ) *txStatusTask {
 	return &txStatusTask{
 		ctx:     ctx,
 		db:      db,
 		nodeCli: nodeCli,
+		nonces:  nonces,
+		slotDur: slotDur,
 	}
 }
 
@@ -40,10 +48,20 @@ func (t *txStatusTask) run() {
 	for {
 		select {
 		case <-ticker.C:
+			start := time.Now()
 			if err := t.updateObjectTxStatus(); err != nil {
 				log.Error("update tx status failed", "error", err)
 			}
 
+			if err := t.expireObjects(); err != nil {
+				log.Error("expire objects failed", "error", err)
+			}
+			metrics.ObserveTask("tx_status", start)
+
+			if err := countObjects(t.db); err != nil {
+				log.Error("count objects failed", "error", err)
+			}
+
 		case <-t.ctx.Done():
 			return
 		}
@@ -53,7 +71,10 @@ func (t *txStatusTask) run() {
 func (t *txStatusTask) updateObjectTxStatus() error {
 	os := make([]*orm.Object, 0)
 	if err := t.db.Model(&orm.Object{}).
-		Where("status in (?,?)", orm.ObjectPending, orm.ObjectCommitted).
+		Where("status in (?,?) and ref_id = ''",
+			orm.ObjectPending,
+			orm.ObjectCommitted,
+		).
 		Limit(10).
 		Find(&os).
 		Error; err != nil {
@@ -74,9 +95,25 @@ func (t *txStatusTask) updateObjectTxStatus() error {
 		if err != nil {
 			if status.Convert(err).Code() == codes.NotFound {
 				if o.CreatedAt.Add(time.Hour).Before(time.Now()) {
-					if err := updateTxStatus(t.db, o.ID, orm.ObjectFailed); err != nil {
+					if err := updateTxStatus(t.db, o.CommitTxHash, orm.ObjectFailed); err != nil {
 						return err
 					}
+
+					// Let the renewal task try again on the renewed object.
+					if o.RenewalOf != "" {
+						if err := t.db.Model(&orm.Object{}).
+							Where("commit_tx_hash = ? and ref_id = ''", o.RenewalOf).
+							Update("renewed_by", "").
+							Error; err != nil {
+							return err
+						}
+					}
+
+					// Later transactions of the owner key can not be
+					// included with a nonce gap.
+					if pk, err := hex.DecodeString(o.OwnerPublicKey); err == nil {
+						t.nonces.fail(pk)
+					}
 				}
 
 				continue
@@ -85,6 +122,11 @@ func (t *txStatusTask) updateObjectTxStatus() error {
 			return errors.Wrapf(err, "tx hash: %s", o.CommitTxHash)
 		}
 
+		if o.Status == orm.ObjectPending {
+			signed := tx.GetSignedTx().GetTx()
+			t.nonces.confirm(signed.GetFrom(), signed.GetNonce())
+		}
+
 		switch o.Status {
 		case orm.ObjectPending:
 			status := orm.ObjectCommitted
@@ -92,13 +134,42 @@ func (t *txStatusTask) updateObjectTxStatus() error {
 				status = orm.ObjectFinalized
 			}
 
-			if err := updateTxStatus(t.db, o.ID, status); err != nil {
+			expiry, err := t.expirySlot(hash, tx.Slot, o.Duration)
+			if err != nil {
+				return err
+			}
+
+			expiresAt, err := t.slotTime(expiry)
+			if err != nil {
+				return err
+			}
+
+			// Deduplicated entries of the renewed object follow the
+			// renewal once it is included.
+			if o.RenewalOf != "" {
+				if err := t.db.Model(&orm.Object{}).
+					Where("commit_tx_hash = ? and ref_id != ''", o.RenewalOf).
+					Update("commit_tx_hash", o.CommitTxHash).
+					Error; err != nil {
+					return err
+				}
+			}
+
+			if err := t.db.Model(&orm.Object{}).
+				Where("commit_tx_hash = ?", o.CommitTxHash).
+				Updates(map[string]any{
+					"status":      status,
+					"commit_slot": uint64(tx.Slot),
+					"expiry_slot": expiry,
+					"expires_at":  expiresAt,
+				}).
+				Error; err != nil {
 				return err
 			}
 
 		case orm.ObjectCommitted:
 			if tx.Finalized {
-				if err := updateTxStatus(t.db, o.ID, orm.ObjectFinalized); err != nil {
+				if err := updateTxStatus(t.db, o.CommitTxHash, orm.ObjectFinalized); err != nil {
 					return err
 				}
 			}
@@ -108,9 +179,67 @@ func (t *txStatusTask) updateObjectTxStatus() error {
 	return nil
 }
 
-func updateTxStatus(db *gorm.DB, id uint64, status orm.ObjectStatus) error {
+// expirySlot returns the end slot of the storage contract created by
+// the commit tx. The contract may not be queryable right after the tx
+// is included, the end is then derived from the committed duration.
+func (t *txStatusTask) expirySlot(
+	hash []byte,
+	slot pbc.Slot,
+	duration uint64,
+) (uint64, error) {
+	resp, err := t.nodeCli.GetStorageContract(
+		t.ctx,
+		&pbc.GetStorageContractRequest{CommitTxHash: hash},
+	)
+	if err != nil {
+		if status.Convert(err).Code() == codes.NotFound {
+			return uint64(slot) + duration, nil
+		}
+
+		return 0, err
+	}
+
+	return uint64(resp.GetContract().GetEnd()), nil
+}
+
+// slotTime estimates the wall clock time of slot from the chain head.
+func (t *txStatusTask) slotTime(slot uint64) (time.Time, error) {
+	head, err := t.nodeCli.GetChainHead(t.ctx, &emptypb.Empty{})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	d := time.Duration(int64(slot)-int64(head.HeadSlot)) * t.slotDur
+	return time.Now().Add(d), nil
+}
+
+// expireObjects marks the objects whose storage contract has ended as
+// expired.
+func (t *txStatusTask) expireObjects() error {
+	head, err := t.nodeCli.GetChainHead(t.ctx, &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+
+	return t.db.Model(&orm.Object{}).
+		Where(
+			"status in (?,?)",
+			orm.ObjectCommitted,
+			orm.ObjectFinalized,
+		).
+		Where(
+			"expiry_slot > 0 and expiry_slot <= ?",
+			uint64(head.HeadSlot),
+		).
+		Update("status", orm.ObjectExpired).
+		Error
+}
+
+// updateTxStatus updates the status of the object committed by the tx
+// along with its deduplicated entries.
+func updateTxStatus(db *gorm.DB, txHash string, status orm.ObjectStatus) error {
 	return db.Model(&orm.Object{}).
-		Where("id = ?", id).
+		Where("commit_tx_hash = ?", txHash).
 		Update("status", status).
 		Error
 }
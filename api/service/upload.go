@@ -2,10 +2,13 @@ package service
 
 import (
 	"encoding/hex"
+	"io"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
 
 	fieldparams "github.com/photon-storage/go-photon/config/fieldparams"
 	"github.com/photon-storage/go-photon/crypto/bls"
@@ -15,11 +18,10 @@ import (
 	pbc "github.com/photon-storage/photon-proto/consensus"
 	pbd "github.com/photon-storage/photon-proto/depot"
 
+	"github.com/photo-storage/dropbox/api/metrics"
 	"github.com/photo-storage/dropbox/database/orm"
 )
 
-const deadlineMod = uint64(300)
-
 var (
 	ErrSectorsPerBlockMismatch = errors.New("SectorsPerBlock setting is different from server")
 	ErrBlocksPerChunkMismatch  = errors.New("BlocksPerChunk setting is different from server")
@@ -27,110 +29,239 @@ var (
 )
 
 // Upload handles the /upload request.
-func (s *Service) Upload(c *gin.Context) error {
-	file, err := c.FormFile("file")
+func (s *Service) Upload(c *gin.Context) (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveUpload(start, err)
+	}(time.Now())
+
+	if err := s.checkUploadLength(c); err != nil {
+		return err
+	}
+
+	// Read the file part straight off the request body instead of
+	// letting gin parse the whole multipart form first.
+	form, err := openUploadForm(c)
 	if err != nil {
 		return err
 	}
+	defer form.Close()
+
+	if err := s.checkPartLength(form); err != nil {
+		return err
+	}
 
-	src, err := file.Open()
+	get := func(name string) string {
+		if v := form.values.Get(name); v != "" {
+			return v
+		}
+		return c.GetHeader(commitHeader(name))
+	}
+	params, err := s.commitParams(get)
 	if err != nil {
 		return err
 	}
-	defer src.Close()
 
-	uf, err := depot.NewUploadFile(
-		src,
-		nil, /* no block signature */
-		nil, /* no encoding */
-	)
+	enc, err := s.uploadEncoding(get)
 	if err != nil {
 		return err
 	}
 
-	sk := nextSk()
-	tx, hash, err := s.buildCommitTx(sk, uf)
+	encr, err := s.uploadEncryption(get)
 	if err != nil {
 		return err
 	}
-	uf.SetTxHash(hash)
 
-	initResp, err := s.depotCli.UploadInit(
-		s.ctx,
-		&pbd.UploadInitRequest{
-			SignedTx:           tx,
-			InitiatorSignature: tx.Signature,
-			FromSignature:      tx.Signature,
-		},
-	)
+	dedup, err := s.dedupRequested(get)
 	if err != nil {
 		return err
 	}
 
-	if depot.SectorsPerBlock != initResp.SectorsPerBlock {
-		return ErrSectorsPerBlockMismatch
+	account := accountOf(c)
+	place, err := s.uploadPlace(account, get("path"), form.fileName())
+	if err != nil {
+		return err
 	}
 
-	if depot.BlocksPerChunk != initResp.BlocksPerChunk {
-		return ErrBlocksPerChunkMismatch
+	// Accounts already at their quota are turned away before reading
+	// the file.
+	if err := s.checkQuota(s.db, account, 0, params.Replicas, 0); err != nil {
+		return err
+	}
+
+	sk, err := s.keys.Key(account)
+	if err != nil {
+		return err
+	}
+
+	// The commit tx needs the hashes of the whole content before any
+	// chunk can be pushed, and depot.UploadFile has no incremental way
+	// to compute them: it holds every chunk of the content in memory.
+	// The memory of an upload is therefore bounded by the max file size
+	// only, not by the chunk size.
+	limited := &limitedReader{r: form.file, max: s.quotaCfg.MaxFileSize}
+	var src io.Reader = limited
+	if encr != nil {
+		if src, err = newEncryptReader(limited, encr.dataKey); err != nil {
+			return err
+		}
+	}
+
+	uf, err := newUploadFile(src, sk, enc)
+	metrics.UploadBytes.Add(float64(limited.n))
+	if limited.exceeded {
+		return errFileTooLarge
+	}
+	if err != nil {
+		return err
+	}
+
+	size := limited.n
+	if err := s.checkQuota(s.db, account, size, params.Replicas, 0); err != nil {
+		return err
 	}
 
-	received := uint32(0)
-	for i := uint32(0); i < uf.NumChunks(); i++ {
-		resp, err := s.depotCli.UploadChunk(s.ctx, &pbd.UploadChunkRequest{
-			Chunk: uf.GetChunk(i),
-		})
+	// Encrypted content never matches, every upload has its own key.
+	if dedup && encr == nil {
+		dup, err := s.findDuplicate(account, sk.PublicKey().Hex(), uf, params, enc)
 		if err != nil {
 			return err
 		}
 
-		if resp.ReceivedChunks != received+1 {
-			return ErrChunkCountMismatch
+		if dup != nil {
+			return s.insertDuplicate(place, dup)
 		}
-		received++
 	}
 
-	return s.insertObject(file.Filename, sk.PublicKey().Hex(), hash.Hex(), uf)
+	d, err := s.depots.pick()
+	if err != nil {
+		return err
+	}
+
+	hash, err := s.commitFile(sk, d, params, fileChunks{uf})
+	if err != nil {
+		return err
+	}
+
+	if encr != nil {
+		if err := s.saveObjectKey(
+			uf.OriginalHash().Hex(),
+			encr,
+			size,
+		); err != nil {
+			return err
+		}
+	}
+
+	return s.insertObject(place, 0, s.newObject(
+		place,
+		sk.PublicKey().Hex(),
+		hash.Hex(),
+		d,
+		params,
+		enc,
+		fileChunks{uf},
+	))
 }
 
-func (s *Service) buildCommitTx(
+// commitFile signs the commit tx of src and pushes src to depot d.
+func (s *Service) commitFile(
 	sk bls.SecretKey,
-	uf *depot.UploadFile,
-) (*pbc.SignedTransaction, sha256.Hash, error) {
-	pk := sk.PublicKey().Bytes()
-	acct, err := s.nodeCli.GetAccount(
+	d *depotClient,
+	params *commitParams,
+	src chunkSource,
+) (sha256.Hash, error) {
+	tx, hash, err := s.buildSignedCommitTx(sk, d, params, src)
+	if err != nil {
+		return sha256.Zero, err
+	}
+	src.SetTxHash(hash)
+
+	// A tx refused by the depot was never submitted, its nonce is given
+	// back. Once the depot holds the tx, an upload failing leaves the tx
+	// never included, the nonces pending after it are resynced.
+	pk := tx.Tx.From
+	if err := s.initUpload(d, tx); err != nil {
+		s.nonces.release(pk, tx.Tx.Nonce)
+		return sha256.Zero, err
+	}
+
+	if err := s.pushChunks(d, src, hash, nil, nil); err != nil {
+		s.nonces.fail(pk)
+		return sha256.Zero, err
+	}
+
+	return hash, nil
+}
+
+func (s *Service) initUpload(
+	d *depotClient,
+	tx *pbc.SignedTransaction,
+) error {
+	initResp, err := d.cli.UploadInit(
 		s.ctx,
-		&pbc.AccountRequest{Address: pk},
+		&pbd.UploadInitRequest{
+			SignedTx:           tx,
+			InitiatorSignature: tx.Signature,
+			FromSignature:      tx.Signature,
+		},
 	)
 	if err != nil {
-		return nil, sha256.Zero, err
+		return err
+	}
+
+	if depot.SectorsPerBlock != initResp.SectorsPerBlock {
+		return ErrSectorsPerBlockMismatch
+	}
+
+	if depot.BlocksPerChunk != initResp.BlocksPerChunk {
+		return ErrBlocksPerChunkMismatch
 	}
 
+	return nil
+}
+
+// txDepot returns the depot the commit transaction stores the object on.
+func (s *Service) txDepot(tx *pbc.SignedTransaction) (*depotClient, error) {
+	return s.depots.get(hex.EncodeToString(
+		tx.GetTx().GetTxDataObjectCommit().GetDepot(),
+	))
+}
+
+// buildCommitTx builds the unsigned commit transaction of content c
+// owned by the account of the given public key and stored by depot d.
+func (s *Service) buildCommitTx(
+	pk []byte,
+	nonce uint64,
+	d *depotClient,
+	params *commitParams,
+	c commitContent,
+) (*pbc.Transaction, sha256.Hash, error) {
 	head, err := s.nodeCli.GetChainHead(s.ctx, &emptypb.Empty{})
 	if err != nil {
 		return nil, sha256.Zero, err
 	}
 
-	deadline := (uint64(head.HeadSlot)/deadlineMod + 2) * deadlineMod
+	mod := s.commitCfg.DeadlineMod
+	deadline := (uint64(head.HeadSlot)/mod + 2) * mod
 	tx := &pbc.Transaction{
 		Type:     uint32(pbc.TxType_OBJECT_COMMIT),
 		From:     pk,
-		ChainId:  uint32(1),
-		Nonce:    acct.Nonce,
-		GasPrice: uint64(1),
+		ChainId:  s.commitCfg.ChainID,
+		Nonce:    nonce,
+		GasPrice: params.GasPrice,
 		GasLimit: fieldparams.ObjectCommitGas,
 		TxDataObjectCommit: &pbc.TxDataObjectCommit{
 			Owner:            pk,
-			Depot:            s.depotPk,
-			DepotDiscoveryId: s.depotDiscoveryID,
-			Hash:             uf.OriginalHash().Bytes(),
-			Size:             uf.OriginalSize(),
-			EncodedHash:      uf.EncodedHash().Bytes(),
-			EncodedSize:      uf.EncodedSize(),
-			NumBlocks:        uf.NumBlocks(),
-			Duration:         pbc.Slot(10000),
-			Fee:              uint64(1),
-			Pledge:           uint64(1),
+			Depot:            d.pk,
+			DepotDiscoveryId: d.discoveryID,
+			Hash:             c.OriginalHash().Bytes(),
+			Size:             c.OriginalSize(),
+			EncodedHash:      c.EncodedHash().Bytes(),
+			EncodedSize:      c.EncodedSize(),
+			NumBlocks:        c.NumBlocks(),
+			Duration:         pbc.Slot(params.Duration),
+			Fee:              params.Fee,
+			Pledge:           params.Pledge,
 			Deadline:         pbc.Slot(deadline),
 		},
 	}
@@ -139,8 +270,30 @@ func (s *Service) buildCommitTx(
 		return nil, sha256.Zero, err
 	}
 
+	return tx, h, nil
+}
+
+func (s *Service) buildSignedCommitTx(
+	sk bls.SecretKey,
+	d *depotClient,
+	params *commitParams,
+	c commitContent,
+) (*pbc.SignedTransaction, sha256.Hash, error) {
+	pk := sk.PublicKey().Bytes()
+	nonce, err := s.nonces.next(s.ctx, pk)
+	if err != nil {
+		return nil, sha256.Zero, err
+	}
+
+	tx, h, err := s.buildCommitTx(pk, nonce, d, params, c)
+	if err != nil {
+		s.nonces.release(pk, nonce)
+		return nil, sha256.Zero, err
+	}
+
 	sig, err := domain.Tx.Sign(tx, sk)
 	if err != nil {
+		s.nonces.release(pk, nonce)
 		return nil, sha256.Zero, err
 	}
 
@@ -150,21 +303,60 @@ func (s *Service) buildCommitTx(
 	}, h, nil
 }
 
+// insertObject lists o at place. The quota of the account is checked
+// again in the same transaction, leaving out the reservation of the
+// upload session of the given id o comes from.
 func (s *Service) insertObject(
-	name string,
+	place *objectPlace,
+	sessionID uint64,
+	o *orm.Object,
+) error {
+	return s.claimPlace(place, func(tx *gorm.DB) error {
+		if err := s.checkQuota(
+			tx,
+			place.account,
+			o.Size,
+			o.Replicas,
+			sessionID,
+		); err != nil {
+			return err
+		}
+
+		return tx.Model(&orm.Object{}).Create(o).Error
+	})
+}
+
+func (s *Service) newObject(
+	place *objectPlace,
 	pk string,
 	txHash string,
-	uf *depot.UploadFile,
-) error {
-	return s.db.Model(&orm.Object{}).Create(&orm.Object{
-		Name:           name,
+	d *depotClient,
+	params *commitParams,
+	enc *uploadEncoding,
+	c chunkSource,
+) *orm.Object {
+	return &orm.Object{
+		Account:        place.account,
+		DirectoryID:    place.dirID,
+		Name:           place.name,
 		OwnerPublicKey: pk,
-		DepotPublicKey: hex.EncodeToString(s.depotPk),
+		DepotPublicKey: d.publicKey(),
 		CommitTxHash:   txHash,
-		Hash:           uf.OriginalHash().Hex(),
-		Size:           uf.OriginalSize(),
-		EncodedHash:    uf.EncodedHash().Hex(),
-		EncodedSize:    uf.EncodedSize(),
+		Hash:           c.OriginalHash().Hex(),
+		Size:           c.OriginalSize(),
+		EncodedHash:    c.EncodedHash().Hex(),
+		EncodedSize:    c.EncodedSize(),
+		ChunkSize:      c.ChunkSize(),
+		Duration:       params.Duration,
+		Fee:            params.Fee,
+		Pledge:         params.Pledge,
+		GasPrice:       params.GasPrice,
+		Replicas:       params.Replicas,
+		Encoding:       enc.Scheme,
+		DataShards:     enc.DataShards,
+		ParityShards:   enc.ParityShards,
+		SignBlocks:     enc.SignBlocks,
+		Retention:      orm.RetentionExpire,
 		Status:         orm.ObjectPending,
-	}).Error
+	}
 }
@@ -2,68 +2,60 @@ package service
 
 import (
 	"context"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-photon/chain/p2p/peers/scorers"
-	"github.com/photon-storage/go-photon/config/config"
 	"github.com/photon-storage/go-photon/p2p"
+	pbd "github.com/photon-storage/photon-proto/depot"
 )
 
 var ErrTimeout = errors.New("find node time out")
 
-func findDepot(
-	ctx context.Context,
-	configType config.ConfigType,
-	bootstrap []string,
-) (string, error) {
-	pf, err := newPeerFinder(ctx, bootstrap)
-	if err != nil {
-		return "", err
-	}
+// defaultDepotRPCPort is the rpc port every depot listened on when the
+// endpoints were hardcoded, used for depots not advertising their port.
+const defaultDepotRPCPort = 8000
 
-	deadline := time.Now().Add(time.Minute)
-	timedOut := false
-	endpoint := ""
-	pf.Run(func(n *enode.Node) bool {
-		if time.Now().After(deadline) {
-			timedOut = true
-			return true
-		}
+// depotRPCPortKey is the ENR key of the depot rpc port. go-photon only
+// defines the "role" entry (p2p.Role) read by the pool, there is no
+// entry of its own for the rpc port, so the key is defined here and
+// depots have to add it to their records. Depots without it fall back
+// to defaultDepotRPCPort.
+const depotRPCPortKey = "rpc"
 
-		var role p2p.Role
-		if err := n.Load(&role); err != nil {
-			log.Error("Load role", "err", err)
-			return false
-		}
+// depotRPCPort is the ENR entry advertising the rpc port of a depot.
+type depotRPCPort uint16
 
-		if role != p2p.RoleDepot {
-			time.Sleep(10 * time.Millisecond)
-			return false
-		}
+func (depotRPCPort) ENRKey() string { return depotRPCPortKey }
 
-		// NOTE: return endpoint directly due to photon node bug,
-		// change this if the bug fixed.
-		switch configType {
-		case config.Devnet:
-			endpoint = "13.214.138.159:8000"
-		case config.Testnet:
-			endpoint = "18.141.161.140:8000"
-		default:
-			endpoint = "127.0.0.1:8000"
-		}
+// depotEndpoint returns the rpc endpoint of the depot node.
+func depotEndpoint(n *enode.Node) (string, error) {
+	if n.IP() == nil {
+		return "", errors.New("depot node has no ip")
+	}
 
-		return true
-	})
+	port := depotRPCPort(defaultDepotRPCPort)
+	if err := n.Load(&port); err != nil {
+		if !enr.IsNotFound(err) {
+			return "", err
+		}
 
-	if timedOut {
-		return "", ErrTimeout
+		log.Warn("depot has no rpc port in its record, use default",
+			"node", n.ID(),
+			"key", depotRPCPortKey,
+			"port", defaultDepotRPCPort,
+		)
 	}
 
-	return endpoint, nil
+	return net.JoinHostPort(n.IP().String(), strconv.Itoa(int(port))), nil
 }
 
 func newPeerFinder(
@@ -90,3 +82,76 @@ func newPeerFinder(
 		BootstrapNodes: bootstrap,
 	})
 }
+
+func bitSetHas(bs *pbd.BitSet, i uint32) bool {
+	if bs == nil || i >= bs.Len || int(i/64) >= len(bs.Vec) {
+		return false
+	}
+
+	return bs.Vec[i/64]&(1<<(i%64)) != 0
+}
+
+func bitSetCount(bs *pbd.BitSet) uint32 {
+	if bs == nil {
+		return 0
+	}
+
+	count := uint32(0)
+	for i := uint32(0); i < bs.Len; i++ {
+		if bitSetHas(bs, i) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// isTransient reports whether err is a gRPC failure that may succeed
+// when the call is repeated.
+func isTransient(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+
+	switch st.Code() {
+	case codes.Unavailable,
+		codes.DeadlineExceeded,
+		codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
+
+// retry runs fn until it succeeds, fails with an error that is not
+// transient, the retries are exhausted or ctx is done. The delay is
+// doubled after each failed attempt.
+func retry(
+	ctx context.Context,
+	retries int,
+	delay time.Duration,
+	fn func() error,
+) error {
+	err := fn()
+	for i := 0; err != nil && i < retries; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !isTransient(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		delay *= 2
+		err = fn()
+	}
+
+	return err
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetry(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name  string
+		ctx   context.Context
+		errs  []error
+		calls int
+		err   error
+	}{
+		{
+			name:  "success",
+			ctx:   context.Background(),
+			errs:  []error{nil},
+			calls: 1,
+		},
+		{
+			name: "transient then success",
+			ctx:  context.Background(),
+			errs: []error{
+				status.Error(codes.Unavailable, ""),
+				status.Error(codes.DeadlineExceeded, ""),
+				status.Error(codes.ResourceExhausted, ""),
+				nil,
+			},
+			calls: 4,
+		},
+		{
+			name: "retries exhausted",
+			ctx:  context.Background(),
+			errs: []error{
+				status.Error(codes.Unavailable, ""),
+				status.Error(codes.Unavailable, ""),
+				status.Error(codes.Unavailable, ""),
+				status.Error(codes.Unavailable, "last"),
+			},
+			calls: 4,
+			err:   status.Error(codes.Unavailable, "last"),
+		},
+		{
+			name:  "not found",
+			ctx:   context.Background(),
+			errs:  []error{status.Error(codes.NotFound, "")},
+			calls: 1,
+			err:   status.Error(codes.NotFound, ""),
+		},
+		{
+			name:  "plain error",
+			ctx:   context.Background(),
+			errs:  []error{errors.New("bad chunk")},
+			calls: 1,
+			err:   errors.New("bad chunk"),
+		},
+		{
+			name:  "context canceled",
+			ctx:   canceled,
+			errs:  []error{status.Error(codes.Unavailable, "")},
+			calls: 1,
+			err:   context.Canceled,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := retry(tc.ctx, 3, time.Millisecond, func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			if calls != tc.calls {
+				t.Fatalf("calls = %d, want %d", calls, tc.calls)
+			}
+
+			if (err == nil) != (tc.err == nil) ||
+				(err != nil && err.Error() != tc.err.Error()) {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
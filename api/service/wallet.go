@@ -0,0 +1,216 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/photon-storage/go-photon/crypto/bls"
+
+	"github.com/photo-storage/dropbox/database/orm"
+)
+
+const (
+	challengeSize = 32
+	challengeTTL  = 5 * time.Minute
+)
+
+// walletScopes are granted to wallets, which can read the objects they
+// own but have no account to upload for.
+var walletScopes = []string{ScopeRead}
+
+type challengeReq struct {
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+type challengeResp struct {
+	Challenge string `json:"challenge"`
+	// Message is what the wallet signs.
+	Message   string `json:"message"`
+	ExpiresAt uint64 `json:"expires_at"`
+}
+
+type walletLoginReq struct {
+	PublicKey string `json:"public_key" binding:"required"`
+	Challenge string `json:"challenge" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+}
+
+// CreateChallenge handles the POST /auth/challenge request, which
+// starts the login of a wallet.
+func (s *Service) CreateChallenge(
+	_ *gin.Context,
+	req *challengeReq,
+) (*challengeResp, error) {
+	pk, err := s.walletKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired challenges are dropped on the way.
+	if err := s.db.
+		Where("expires_at < ?", time.Now()).
+		Delete(&orm.AuthChallenge{}).Error; err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	ch := &orm.AuthChallenge{
+		PublicKey: pk.Hex(),
+		Challenge: hex.EncodeToString(b),
+		ExpiresAt: time.Now().Add(challengeTTL),
+	}
+	if err := s.db.Model(&orm.AuthChallenge{}).Create(ch).Error; err != nil {
+		return nil, err
+	}
+
+	return &challengeResp{
+		Challenge: ch.Challenge,
+		Message:   challengeMessage(ch.Challenge),
+		ExpiresAt: uint64(ch.ExpiresAt.Unix()),
+	}, nil
+}
+
+// WalletLogin handles the POST /auth/wallet request carrying the
+// signature of a challenge by the wallet key. It issues a bearer token
+// limited to the objects owned by the key.
+func (s *Service) WalletLogin(
+	_ *gin.Context,
+	req *walletLoginReq,
+) (*tokenResp, error) {
+	pk, err := s.walletKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := hex.DecodeString(req.Signature)
+	if err != nil || len(raw) != blsSignatureLength {
+		return nil, errInvalidSignature
+	}
+
+	sig, err := bls.SignatureFromBytes(raw)
+	if err != nil {
+		return nil, errInvalidSignature
+	}
+
+	// The challenge is consumed before checking the signature so that
+	// it can be answered once only.
+	res := s.db.
+		Where(
+			"public_key = ? and challenge = ? and expires_at >= ?",
+			pk.Hex(),
+			req.Challenge,
+			time.Now(),
+		).
+		Delete(&orm.AuthChallenge{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, errInvalidChallenge
+	}
+
+	if !sig.Verify(pk, []byte(challengeMessage(req.Challenge))) {
+		return nil, errInvalidSignature
+	}
+
+	now := time.Now()
+	claims := &tokenClaims{
+		Subject:   pk.Hex(),
+		PublicKey: pk.Hex(),
+		Scope:     strings.Join(walletScopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.authCfg.TokenTTL).Unix(),
+	}
+
+	token, err := signToken(s.tokenSecret, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResp{
+		Token:     token,
+		Scopes:    walletScopes,
+		ExpiresAt: uint64(claims.ExpiresAt),
+	}, nil
+}
+
+// ownedObjects selects the objects of the principal of the request,
+// which are those of its account or, for wallets, those the wallet key
+// signed the commit tx of.
+func (s *Service) ownedObjects(c *gin.Context) *gorm.DB {
+	p := PrincipalOf(c)
+	query := s.db.Model(&orm.Object{})
+	if p.PublicKey == "" {
+		return query.Where("account = ?", p.Account)
+	}
+
+	// Client signed uploads keep the key as given by the client.
+	pk, err := parsePublicKey(p.PublicKey)
+	if err != nil {
+		return query.Where("1 = 0")
+	}
+
+	return query.Where(
+		"client_signed = ? and owner_public_key in ?",
+		true,
+		[]string{pk.Hex(), hex.EncodeToString(pk.Bytes())},
+	)
+}
+
+// requireAccount returns the account of the request, it fails for
+// wallets which have no account.
+func requireAccount(c *gin.Context) (string, error) {
+	account := accountOf(c)
+	if account == "" {
+		return "", errAccountRequired
+	}
+
+	return account, nil
+}
+
+// walletKey parses the public key of a wallet logging in. Keys held by
+// the service are refused, some of them can be derived by anyone and
+// none of them belongs to a wallet.
+func (s *Service) walletKey(v string) (bls.PublicKey, error) {
+	pk, err := parsePublicKey(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.keys.KeyOf(pk.Hex()); err == nil {
+		return nil, errForbidden
+	} else if err != errKeyNotFound {
+		return nil, err
+	}
+
+	return pk, nil
+}
+
+func parsePublicKey(v string) (bls.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+	if err != nil || len(raw) != blsPubkeyLength {
+		return nil, errInvalidPublicKey
+	}
+
+	pk, err := bls.PublicKeyFromBytes(raw)
+	if err != nil {
+		return nil, errInvalidPublicKey
+	}
+
+	return pk, nil
+}
+
+func challengeMessage(challenge string) string {
+	return fmt.Sprintf("Sign in to dropbox: %s", challenge)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/photon-storage/go-photon/crypto/bls"
+)
+
+// heldKeys is a key provider holding a single key.
+type heldKeys struct {
+	sk  bls.SecretKey
+	err error
+}
+
+func (k *heldKeys) Key(string) (bls.SecretKey, error) {
+	return k.sk, nil
+}
+
+func (k *heldKeys) KeyOf(pk string) (bls.SecretKey, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+
+	if pk != k.sk.PublicKey().Hex() {
+		return nil, errKeyNotFound
+	}
+
+	return k.sk, nil
+}
+
+func randKey(t *testing.T) bls.SecretKey {
+	sk, err := bls.RandKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sk
+}
+
+func TestWalletLoginRefusesServiceKey(t *testing.T) {
+	held := randKey(t)
+	s := &Service{keys: &heldKeys{sk: held}}
+	pk := hex.EncodeToString(held.PublicKey().Bytes())
+
+	if _, err := s.CreateChallenge(
+		nil,
+		&challengeReq{PublicKey: pk},
+	); err != errForbidden {
+		t.Fatalf("challenge of a service key: got %v, want %v", err, errForbidden)
+	}
+
+	// The key is refused before its challenge or signature is looked at.
+	if _, err := s.WalletLogin(nil, &walletLoginReq{
+		PublicKey: "0x" + pk,
+		Challenge: "00",
+		Signature: hex.EncodeToString(held.Sign([]byte("00")).Bytes()),
+	}); err != errForbidden {
+		t.Fatalf("login with a service key: got %v, want %v", err, errForbidden)
+	}
+
+	// A wallet key gets through to the signature check.
+	wallet := randKey(t)
+	if _, err := s.WalletLogin(nil, &walletLoginReq{
+		PublicKey: hex.EncodeToString(wallet.PublicKey().Bytes()),
+		Challenge: "00",
+		Signature: "00",
+	}); err != errInvalidSignature {
+		t.Fatalf("login with a wallet key: got %v, want %v", err, errInvalidSignature)
+	}
+}
+
+func TestWalletKeyLookupFailure(t *testing.T) {
+	// A key store failing must not let a key through as a wallet key.
+	failure := errors.New("key store unavailable")
+	s := &Service{keys: &heldKeys{sk: randKey(t), err: failure}}
+	pk := hex.EncodeToString(randKey(t).PublicKey().Bytes())
+
+	if _, err := s.walletKey(pk); err != failure {
+		t.Fatalf("wallet key: got %v, want %v", err, failure)
+	}
+}
@@ -131,22 +131,24 @@ func action(ctx *cli.Context) error {
 	service, err := service.New(
 		ctx.Context,
 		db,
-		cfg.NodeEndpoint,
 		configType,
-		cfg.DepotBootstrap,
+		cfg.Service,
 	)
 	if err != nil {
 		return err
 	}
 
-	server.New(cfg.Port, service).Run()
+	server.New(cfg.Port, cfg.MetricsPort, cfg.RateLimit, service).Run()
 	return nil
 }
 
 // Config defines the config for api service.
 type Config struct {
-	Port           int          `yaml:"port"`
-	MySQL          mysql.Config `yaml:"mysql"`
-	NodeEndpoint   string       `yaml:"node_endpoint"`
-	DepotBootstrap []string     `yaml:"depot_bootstrap"`
+	Port int `yaml:"port"`
+	// MetricsPort is the port serving the metrics, apart from the API
+	// port so that it is not exposed publicly. Zero disables it.
+	MetricsPort int                    `yaml:"metrics_port"`
+	MySQL       mysql.Config           `yaml:"mysql"`
+	RateLimit   server.RateLimitConfig `yaml:"rate_limit"`
+	Service     service.Config         `yaml:",inline"`
 }
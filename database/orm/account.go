@@ -0,0 +1,14 @@
+package orm
+
+import "time"
+
+// Account is a gorm table definition represents the users of the
+// service. Objects, folders and upload sessions belong to an account
+// by its name.
+type Account struct {
+	ID           uint64 `gorm:"primary_key"`
+	Name         string
+	PasswordHash string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
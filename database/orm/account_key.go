@@ -0,0 +1,13 @@
+package orm
+
+import "time"
+
+// AccountKey is a gorm table definition represents the binding
+// between an account and its signing key.
+type AccountKey struct {
+	ID        uint64 `gorm:"primary_key"`
+	Account   string
+	PublicKey string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,18 @@
+package orm
+
+import "time"
+
+// APIKey is a gorm table definition represents the API keys of an
+// account. Only the SHA-256 hash of the key secret is stored.
+type APIKey struct {
+	ID         uint64 `gorm:"primary_key"`
+	Account    string
+	KeyID      string
+	SecretHash string
+	Name       string
+	// Scopes is the space separated list of the scopes granted.
+	Scopes     string
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
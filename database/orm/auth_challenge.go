@@ -0,0 +1,14 @@
+package orm
+
+import "time"
+
+// AuthChallenge is a gorm table definition represents the challenges
+// issued to wallets logging in with their BLS key. A challenge can be
+// answered once before it expires.
+type AuthChallenge struct {
+	ID        uint64 `gorm:"primary_key"`
+	PublicKey string
+	Challenge string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,14 @@
+package orm
+
+import "time"
+
+// Directory is a gorm table definition represents the folders of an
+// account. Top level folders have no parent.
+type Directory struct {
+	ID        uint64 `gorm:"primary_key"`
+	Account   string
+	ParentID  uint64
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
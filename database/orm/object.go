@@ -11,6 +11,7 @@ const (
 	ObjectCommitted
 	ObjectFinalized
 	ObjectFailed
+	ObjectExpired
 )
 
 var objectMap = map[ObjectStatus]string{
@@ -18,23 +19,66 @@ var objectMap = map[ObjectStatus]string{
 	ObjectCommitted: "committed",
 	ObjectFinalized: "finalized",
 	ObjectFailed:    "failed",
+	ObjectExpired:   "expired",
 }
 
-// Object is a gorm table definition represents the objects.
+// ObjectRetention represents what happens to an object
+// when its storage contract expires.
+type ObjectRetention uint8
+
+const (
+	RetentionExpire ObjectRetention = iota + 1
+	RetentionKeep
+)
+
+var retentionMap = map[ObjectRetention]string{
+	RetentionExpire: "expire",
+	RetentionKeep:   "keep",
+}
+
+// Object is a gorm table definition represents the objects. The table
+// derives a unique listed_path from the account, folder and name of the
+// listed objects, those neither replicas, renewed nor failed, so that
+// no two of them share a path. Its definition hardcodes ObjectFailed.
+// ChunkSize is the number of content bytes carried by every chunk but
+// the last, zero for objects stored before it was recorded.
 type Object struct {
 	ID             uint64 `gorm:"primary_key"`
+	Account        string
+	DirectoryID    uint64
 	Name           string
 	OwnerPublicKey string
 	DepotPublicKey string
 	CommitTxHash   string
+	RefID          string
 	Hash           string
 	Size           uint64
 	EncodedHash    string
 	EncodedSize    uint64
-	Cid            string
-	Status         ObjectStatus
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
+	ChunkSize      uint64
+	Duration       uint64
+	Fee            uint64
+	Pledge         uint64
+	GasPrice       uint64
+	CommitSlot     uint64
+	ExpirySlot     uint64
+	ExpiresAt      *time.Time
+	Retention      ObjectRetention
+	RenewalOf      string
+	RenewedBy      string
+	Replicas       uint64
+	ReplicaOf      string
+	Encoding       string
+	DataShards     uint32
+	ParityShards   uint32
+	SignBlocks     bool
+	// ClientSigned marks objects whose commit tx was signed by the
+	// owner's wallet rather than a key held by the service.
+	ClientSigned bool
+	Cid          string
+	Status       ObjectStatus
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 func (o ObjectStatus) String() string {
@@ -44,3 +88,22 @@ func (o ObjectStatus) String() string {
 
 	return "invalid"
 }
+
+func (r ObjectRetention) String() string {
+	if v, ok := retentionMap[r]; ok {
+		return v
+	}
+
+	return "invalid"
+}
+
+// ParseRetention returns the retention of the given name.
+func ParseRetention(name string) (ObjectRetention, bool) {
+	for r, v := range retentionMap {
+		if v == name {
+			return r, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,16 @@
+package orm
+
+import "time"
+
+// ObjectKey is a gorm table definition represents the wrapped data key
+// of an encrypted object. It is shared by all copies of the content.
+type ObjectKey struct {
+	ID         uint64 `gorm:"primary_key"`
+	Hash       string
+	WrappedKey []byte
+	KeySource  string
+	Algorithm  string
+	PlainSize  uint64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,75 @@
+package orm
+
+import "time"
+
+// UploadSessionStatus represents the status of
+// different life cycles of UploadSession.
+type UploadSessionStatus uint8
+
+const (
+	SessionOpen UploadSessionStatus = iota + 1
+	SessionCommitting
+	SessionCompleted
+	SessionAborted
+	SessionFailed
+	SessionAwaitingSignature
+	SessionReceiving
+)
+
+var uploadSessionMap = map[UploadSessionStatus]string{
+	SessionOpen:       "open",
+	SessionCommitting: "committing",
+	SessionCompleted:  "completed",
+	SessionAborted:    "aborted",
+	SessionFailed:     "failed",
+
+	SessionAwaitingSignature: "awaiting_signature",
+	SessionReceiving:         "receiving",
+}
+
+// UploadSession is a gorm table definition represents the resumable
+// upload sessions. The file content is staged on the local disk until
+// the session is completed and pushed to the depot, so a session can
+// only be resumed by the node it was created on, named by Node. The commit
+// transaction of a client signed session is signed by the owner
+// instead of the service. Failed commit attempts are retried with
+// backoff from NextAttemptAt.
+type UploadSession struct {
+	ID             uint64 `gorm:"primary_key"`
+	SessionID      string
+	Node           string
+	Account        string
+	DirectoryID    uint64
+	Name           string
+	Size           uint64
+	Received       uint64
+	Duration       uint64
+	Fee            uint64
+	Pledge         uint64
+	GasPrice       uint64
+	Replicas       uint64
+	Encoding       string
+	DataShards     uint32
+	ParityShards   uint32
+	SignBlocks     bool
+	WrappedKey     []byte
+	OwnerPublicKey string
+	ClientSigned   bool
+	CommitTxHash   string
+	SignedTx       []byte
+	NumChunks      uint32
+	PushedChunks   uint32
+	Attempts       uint32
+	NextAttemptAt  *time.Time
+	Status         UploadSessionStatus
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (s UploadSessionStatus) String() string {
+	if v, ok := uploadSessionMap[s]; ok {
+		return v
+	}
+
+	return "invalid"
+}
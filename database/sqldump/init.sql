@@ -21,6 +21,104 @@ The database initialization process is as follows:
 /*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
 /*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
 
+--
+-- Table structure for table `accounts`
+--
+
+DROP TABLE IF EXISTS `accounts`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `accounts` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `password_hash` char(60) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name_UNIQUE` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `account_keys`
+--
+
+DROP TABLE IF EXISTS `account_keys`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `account_keys` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `account` varchar(255) NOT NULL,
+  `public_key` char(192) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `account_UNIQUE` (`account`),
+  UNIQUE KEY `public_key_UNIQUE` (`public_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `api_keys`
+--
+
+DROP TABLE IF EXISTS `api_keys`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `api_keys` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `account` varchar(255) NOT NULL,
+  `key_id` char(16) NOT NULL,
+  `secret_hash` char(64) NOT NULL,
+  `name` varchar(255) NOT NULL DEFAULT '',
+  `scopes` varchar(255) NOT NULL DEFAULT '',
+  `last_used_at` timestamp NULL DEFAULT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `key_id_UNIQUE` (`key_id`),
+  KEY `account` (`account`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `auth_challenges`
+--
+
+DROP TABLE IF EXISTS `auth_challenges`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `auth_challenges` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `public_key` char(192) NOT NULL,
+  `challenge` char(64) NOT NULL,
+  `expires_at` timestamp NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `challenge_UNIQUE` (`challenge`),
+  KEY `expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `directories`
+--
+
+DROP TABLE IF EXISTS `directories`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `directories` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `account` varchar(255) NOT NULL DEFAULT '',
+  `parent_id` int(11) NOT NULL DEFAULT '0',
+  `name` varchar(255) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `account_parent_id_name_UNIQUE` (`account`,`parent_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `objects`
 --
@@ -30,20 +128,114 @@ DROP TABLE IF EXISTS `objects`;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `objects` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
+  `account` varchar(255) NOT NULL DEFAULT '',
+  `directory_id` int(11) NOT NULL DEFAULT '0',
   `name` varchar(1024) NOT NULL,
   `commit_tx_hash` char(64) NOT NULL,
+  `ref_id` char(32) NOT NULL DEFAULT '',
   `hash` char(64) NOT NULL,
-  `size` int(11) NOT NULL,
+  `size` bigint(20) unsigned NOT NULL,
   `encoded_hash` char(64) NOT NULL,
-  `encoded_size` int(11) NOT NULL,
+  `encoded_size` bigint(20) unsigned NOT NULL,
+  `chunk_size` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `duration` bigint(20) NOT NULL DEFAULT '0',
+  `fee` bigint(20) NOT NULL DEFAULT '0',
+  `pledge` bigint(20) NOT NULL DEFAULT '0',
+  `gas_price` bigint(20) NOT NULL DEFAULT '0',
+  `commit_slot` bigint(20) NOT NULL DEFAULT '0',
+  `expiry_slot` bigint(20) NOT NULL DEFAULT '0',
+  `expires_at` timestamp NULL DEFAULT NULL,
+  `retention` tinyint(1) NOT NULL DEFAULT '1',
+  `renewal_of` char(64) NOT NULL DEFAULT '',
+  `renewed_by` char(64) NOT NULL DEFAULT '',
+  `replicas` int(11) NOT NULL DEFAULT '1',
+  `replica_of` char(64) NOT NULL DEFAULT '',
+  `encoding` varchar(32) NOT NULL DEFAULT 'none',
+  `data_shards` int(11) NOT NULL DEFAULT '0',
+  `parity_shards` int(11) NOT NULL DEFAULT '0',
+  `sign_blocks` tinyint(1) NOT NULL DEFAULT '0',
+  `client_signed` tinyint(1) NOT NULL DEFAULT '0',
   `cid` varchar(255) DEFAULT NULL,
   `owner_public_key` char(192) NOT NULL,
   `depot_public_key` char(192) NOT NULL,
   `status` tinyint(1) NOT NULL,
   `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  `listed_path` char(64) GENERATED ALWAYS AS (if(((`replica_of` = '') and (`renewed_by` = '') and (`status` <> 4)),sha2(concat(`account`,'/',`directory_id`,'/',`name`),256),NULL)) STORED,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `commit_tx_hash_ref_id_UNIQUE` (`commit_tx_hash`,`ref_id`),
+  UNIQUE KEY `listed_path_UNIQUE` (`listed_path`),
+  KEY `hash_owner_public_key` (`hash`,`owner_public_key`),
+  KEY `owner_public_key` (`owner_public_key`),
+  KEY `retention_expiry_slot` (`retention`,`expiry_slot`),
+  KEY `status_expires_at` (`status`,`expires_at`),
+  KEY `replica_of` (`replica_of`),
+  KEY `account_directory_id_name` (`account`,`directory_id`,`name`(255))
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `object_keys`
+--
+
+DROP TABLE IF EXISTS `object_keys`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `object_keys` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `hash` char(64) NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  `key_source` varchar(16) NOT NULL,
+  `algorithm` varchar(32) NOT NULL,
+  `plain_size` bigint(20) unsigned NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash_UNIQUE` (`hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `upload_sessions`
+--
+
+DROP TABLE IF EXISTS `upload_sessions`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `upload_sessions` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `session_id` char(32) NOT NULL,
+  `node` varchar(255) NOT NULL DEFAULT '',
+  `account` varchar(255) NOT NULL DEFAULT '',
+  `directory_id` int(11) NOT NULL DEFAULT '0',
+  `name` varchar(1024) NOT NULL,
+  `size` bigint(20) unsigned NOT NULL,
+  `received` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `duration` bigint(20) NOT NULL DEFAULT '0',
+  `fee` bigint(20) NOT NULL DEFAULT '0',
+  `pledge` bigint(20) NOT NULL DEFAULT '0',
+  `gas_price` bigint(20) NOT NULL DEFAULT '0',
+  `replicas` int(11) NOT NULL DEFAULT '1',
+  `encoding` varchar(32) NOT NULL DEFAULT 'none',
+  `data_shards` int(11) NOT NULL DEFAULT '0',
+  `parity_shards` int(11) NOT NULL DEFAULT '0',
+  `sign_blocks` tinyint(1) NOT NULL DEFAULT '0',
+  `wrapped_key` varbinary(255) DEFAULT NULL,
+  `owner_public_key` char(192) NOT NULL DEFAULT '',
+  `client_signed` tinyint(1) NOT NULL DEFAULT '0',
+  `commit_tx_hash` char(64) NOT NULL DEFAULT '',
+  `signed_tx` blob,
+  `num_chunks` int(11) NOT NULL DEFAULT '0',
+  `pushed_chunks` int(11) NOT NULL DEFAULT '0',
+  `attempts` int(11) NOT NULL DEFAULT '0',
+  `next_attempt_at` timestamp NULL DEFAULT NULL,
+  `status` tinyint(1) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `commit_tx_hash_UNIQUE` (`commit_tx_hash`)
+  UNIQUE KEY `session_id_UNIQUE` (`session_id`),
+  KEY `status_updated_at` (`status`,`updated_at`),
+  KEY `node_status` (`node`,`status`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 